@@ -1,5 +1,7 @@
 #!/bin/bash
 set -e
 
-go build -o ./quicgo-server ./cmd/server/main.go
-go build -o ./quicgo-client ./cmd/client/main.go
\ No newline at end of file
+go build -o ./quicgo-server ./cmd/server
+go build -o ./quicgo-client ./cmd/client
+go build -o ./quicgo-bench ./cmd/bench
+go build -o ./quicgo-gencert ./cmd/gencert
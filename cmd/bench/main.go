@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	log "github.com/sirupsen/logrus"
+)
+
+// job is a request to send, scheduled at the time it carries
+type job struct {
+	scheduled time.Time
+}
+
+func main() {
+	verbose := flag.Bool("v", false, "verbose")
+	insecure := flag.Bool("insecure", false, "skip certificate verification")
+	caCertFile := flag.String("ca-cert", "", "Path to the CA cert file")
+	conns := flag.Int("conns", 1, "Number of QUIC connections to the server")
+	concurrency := flag.Int("concurrency", 1, "Number of requests in flight on each connection")
+	rate := flag.Float64("rate", 0, "Requests per second over all the connections (0 sends them as fast as the responses come)")
+	size := flag.Int("size", 1024, "Size in bytes of the payload, downloaded from /<size> (served without -www) or uploaded to /bench/upload")
+	upload := flag.Bool("upload", false, "Upload the payload to /bench/upload instead of downloading it")
+	duration := flag.Duration("duration", 10*time.Second, "Duration of the benchmark")
+	requestTimeout := flag.Duration("timeout", 10*time.Second, "Maximum time allowed for each request")
+	socketPerConn := flag.Bool("socket-per-conn", false, "Dial each connection from its own UDP socket instead of sharing one")
+	flag.Parse()
+
+	log.SetOutput(os.Stdout)
+	if *verbose {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+	if flag.NArg() != 1 {
+		log.Fatalf("Usage: %s [options] https://host:port", os.Args[0])
+	}
+	if *conns < 1 || *concurrency < 1 || *size < 0 || *rate < 0 {
+		log.Fatal("-conns and -concurrency must be positive, -size and -rate not negative")
+	}
+	baseURL, err := url.Parse(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	target := baseURL.ResolveReference(&url.URL{Path: "/" + strconv.Itoa(*size)}).String()
+	method := http.MethodGet
+	var payload []byte
+	if *upload {
+		target = baseURL.ResolveReference(&url.URL{Path: "/bench/upload"}).String()
+		method = http.MethodPost
+		payload = make([]byte, *size)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(*caCertFile) > 0 {
+		caCertRaw, err := os.ReadFile(*caCertFile)
+		if err != nil {
+			log.Fatalf("Unable to read CA cert file %s: %v", *caCertFile, err)
+		}
+		if ok := pool.AppendCertsFromPEM(caCertRaw); !ok {
+			log.Fatalf("Could not add the CA certificate of %s to the pool", *caCertFile)
+		}
+	}
+	tlsConf := &tls.Config{
+		RootCAs:            pool,
+		InsecureSkipVerify: *insecure,
+	}
+
+	// the connections share the socket of tr unless -socket-per-conn is set
+	var dial func(context.Context, string, *tls.Config, *quic.Config) (quic.EarlyConnection, error)
+	if !*socketPerConn {
+		udpConn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tr := &quic.Transport{Conn: udpConn}
+		defer udpConn.Close()
+		defer tr.Close()
+		dial = func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+			udpAddr, err := net.ResolveUDPAddr("udp", addr)
+			if err != nil {
+				return nil, err
+			}
+			return tr.DialEarly(ctx, udpAddr, tlsCfg, cfg)
+		}
+	}
+	// one round tripper per connection: each one keeps a single connection to the server
+	clients := make([]*http.Client, *conns)
+	for i := range clients {
+		rt := &http3.RoundTripper{TLSClientConfig: tlsConf, QuicConfig: &quic.Config{}, Dial: dial}
+		defer rt.Close()
+		clients[i] = &http.Client{Transport: rt, Timeout: *requestTimeout}
+	}
+	// the handshakes are not part of the measures
+	ping := baseURL.ResolveReference(&url.URL{Path: "/ping"}).String()
+	for i, c := range clients {
+		rsp, err := c.Get(ping)
+		if err != nil {
+			log.Fatalf("Unable to open connection %d: %v", i, err)
+		}
+		io.Copy(io.Discard, rsp.Body)
+		rsp.Body.Close()
+	}
+	log.Infof("%d connections open to %s, %s %s for %v with %d requests in flight per connection",
+		*conns, baseURL.Host, method, target, *duration, *concurrency)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	workers := *conns * *concurrency
+	jobs := make(chan job, workers)
+	results := make([]*stats, workers)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		results[i] = newStats()
+		wg.Add(1)
+		go func(c *http.Client, s *stats) {
+			defer wg.Done()
+			for j := range jobs {
+				n, err := send(ctx, c, method, target, payload)
+				if err != nil && ctx.Err() != nil {
+					// cut by the end of the benchmark
+					return
+				}
+				s.add(time.Since(j.scheduled), n, err)
+			}
+		}(clients[i%*conns], results[i])
+	}
+	missed := schedule(ctx, jobs, *rate)
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	total := newStats()
+	for _, s := range results {
+		total.merge(s)
+	}
+	total.report(elapsed, missed)
+}
+
+// schedule queues the jobs until ctx is done, at rate per second or as soon as a worker is free when rate is 0.
+// It returns the number of jobs not sent because all the workers were busy.
+func schedule(ctx context.Context, jobs chan<- job, rate float64) int {
+	if rate == 0 {
+		for {
+			select {
+			case jobs <- job{scheduled: time.Now()}:
+			case <-ctx.Done():
+				return 0
+			}
+		}
+	}
+	// open loop: the latencies are measured from the scheduled times, so that the time spent
+	// waiting for a worker is not hidden when the server can't keep up
+	var missed int
+	interval := time.Duration(float64(time.Second) / rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-ticker.C:
+			select {
+			case jobs <- job{scheduled: t}:
+			default:
+				missed++
+			}
+		case <-ctx.Done():
+			return missed
+		}
+	}
+}
+
+// send sends a request and reads its response, returning the number of payload bytes transferred
+func send(ctx context.Context, c *http.Client, method, target string, payload []byte) (int64, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return 0, err
+	}
+	rsp, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	n, err := io.Copy(io.Discard, rsp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status %s", rsp.Status)
+	}
+	if payload != nil {
+		return int64(len(payload)), nil
+	}
+	return n, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxReportedErrors is the number of distinct errors printed in the report
+const maxReportedErrors = 10
+
+// stats are the measures of a worker, merged at the end
+type stats struct {
+	latencies []time.Duration
+	bytes     int64
+	errors    map[string]int
+}
+
+func newStats() *stats {
+	return &stats{errors: make(map[string]int)}
+}
+
+func (s *stats) add(latency time.Duration, n int64, err error) {
+	if err != nil {
+		s.errors[err.Error()]++
+		return
+	}
+	s.latencies = append(s.latencies, latency)
+	s.bytes += n
+}
+
+func (s *stats) merge(o *stats) {
+	s.latencies = append(s.latencies, o.latencies...)
+	s.bytes += o.bytes
+	for e, n := range o.errors {
+		s.errors[e] += n
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
+
+// report prints the throughput and the latency percentiles of the requests completed in elapsed
+func (s *stats) report(elapsed time.Duration, missed int) {
+	var failed int
+	for _, n := range s.errors {
+		failed += n
+	}
+	log.Infof("%d requests in %.2fs: %d succeeded, %d failed, %d not sent (all the workers busy)",
+		len(s.latencies)+failed, elapsed.Seconds(), len(s.latencies), failed, missed)
+	log.Infof("Throughput: %.1f requests/s, %.2f Mbit/s of payload",
+		float64(len(s.latencies))/elapsed.Seconds(), float64(s.bytes)*8/1e6/elapsed.Seconds())
+	if len(s.latencies) > 0 {
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		log.Infof("Latency: min %v, p50 %v, p90 %v, p99 %v, p99.9 %v, max %v",
+			s.latencies[0], percentile(s.latencies, 50), percentile(s.latencies, 90), percentile(s.latencies, 99),
+			percentile(s.latencies, 99.9), s.latencies[len(s.latencies)-1])
+	}
+
+	errs := make([]string, 0, len(s.errors))
+	for e := range s.errors {
+		errs = append(errs, e)
+	}
+	sort.Slice(errs, func(i, j int) bool { return s.errors[errs[i]] > s.errors[errs[j]] })
+	for i, e := range errs {
+		if i == maxReportedErrors {
+			log.Warnf("... and %d other errors", len(errs)-i)
+			break
+		}
+		log.Warnf("%d times: %s", s.errors[e], e)
+	}
+}
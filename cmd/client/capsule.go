@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// capsuleEchoProtocol is the :protocol of the extended CONNECT requests of /demo/capsules
+	capsuleEchoProtocol = "capsule-echo"
+	// capsuleDatagram is the DATAGRAM capsule type (RFC 9297)
+	capsuleDatagram http3.CapsuleType = 0x0
+	// capsuleGrease is a reserved capsule type, which the server must drop
+	capsuleGrease http3.CapsuleType = 0x29*7 + 0x17
+)
+
+// readCapsule reads the next capsule of r, of max bytes at most.
+// The value reader of http3.ParseCapsule fails on the short reads, the header is parsed here.
+func readCapsule(r *bufio.Reader, max int) (http3.CapsuleType, []byte, error) {
+	ct, err := quicvarint.Read(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := quicvarint.Read(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > uint64(max) {
+		return 0, nil, fmt.Errorf("capsule of %d bytes, expected %d at most", length, max)
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return 0, nil, err
+	}
+	return http3.CapsuleType(ct), value, nil
+}
+
+// runCapsules opens a capsule-echo session on the /demo/capsules endpoint of the server at base, sends count
+// DATAGRAM capsules of size bytes one after the other and checks that each one is sent back
+func runCapsules(hclient *http.Client, base string, count, size int) error {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	u := baseURL.ResolveReference(&url.URL{Path: "/demo/capsules"})
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	req, err := http.NewRequest(http.MethodConnect, u.String(), pr)
+	if err != nil {
+		return err
+	}
+	// extended CONNECT (RFC 9220), the http3 round tripper sends Proto as the :protocol
+	req.Proto = capsuleEchoProtocol
+	req.Header.Set("Capsule-Protocol", "?1")
+	rsp, err := hclient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the capsule session was refused: %s", rsp.Status)
+	}
+	log.Infof("Capsule session open on %s, sending %d DATAGRAM capsules of %d bytes", u, count, size)
+
+	out := bufio.NewWriter(pw)
+	// a capsule of a reserved type first, to check that the server skips the types it does not know
+	if err := http3.WriteCapsule(out, capsuleGrease, []byte("grease")); err != nil {
+		return err
+	}
+	in := bufio.NewReader(rsp.Body)
+	rtts := make([]time.Duration, 0, count)
+	payload := make([]byte, size)
+	for i := 0; i < count; i++ {
+		if _, err := rand.Read(payload); err != nil {
+			return err
+		}
+		start := time.Now()
+		if err := http3.WriteCapsule(out, capsuleDatagram, payload); err != nil {
+			return err
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		ct, echo, err := readCapsule(in, size)
+		if err != nil {
+			return fmt.Errorf("capsule %d not sent back: %w", i, err)
+		}
+		if ct != capsuleDatagram || !bytes.Equal(echo, payload) {
+			return fmt.Errorf("capsule %d sent back as a capsule of type %#x and %d bytes", i, uint64(ct), len(echo))
+		}
+		rtt := time.Since(start)
+		log.Debugf("capsule %d: sent back in %v", i, rtt)
+		rtts = append(rtts, rtt)
+	}
+	// closing the request stream ends the session
+	pw.Close()
+	if _, err := io.Copy(io.Discard, in); err != nil {
+		return err
+	}
+
+	if len(rtts) == 0 {
+		return nil
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	log.Infof("%d DATAGRAM capsules sent back: min %v, p50 %v, max %v", len(rtts), rtts[0], rtts[len(rtts)/2], rtts[len(rtts)-1])
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type compareResult struct {
+	proto   string
+	status  int
+	bytes   int64
+	headers time.Duration // time to the response headers
+	total   time.Duration
+	err     error
+}
+
+func fetchTimed(hclient *http.Client, reqOpts *requestOptions, u string) compareResult {
+	req, err := reqOpts.newRequest(u)
+	if err != nil {
+		return compareResult{err: err}
+	}
+	start := time.Now()
+	rsp, err := hclient.Do(req)
+	if err != nil {
+		return compareResult{err: err}
+	}
+	defer rsp.Body.Close()
+	res := compareResult{proto: rsp.Proto, status: rsp.StatusCode, headers: time.Since(start)}
+	res.bytes, res.err = io.Copy(io.Discard, rsp.Body)
+	res.total = time.Since(start)
+	return res
+}
+
+// runCompare fetches u twice over HTTP/1.1, HTTP/2 and HTTP/3, each with a new connection,
+// and prints the timings of the first request (including the handshake) and of the second one.
+// HTTP/1.1 and HTTP/2 require the TCP fallback of the server on the same port.
+func runCompare(h3client *http.Client, reqOpts *requestOptions, u string, tlsConf *tls.Config, timeout time.Duration) {
+	h1 := &http.Transport{
+		TLSClientConfig: tlsConf.Clone(),
+		DialContext:     resolved.dialTCP,
+		// a non-nil empty map disables HTTP/2
+		TLSNextProto: map[string]func(string, *tls.Conn) http.RoundTripper{},
+	}
+	h2 := &http.Transport{
+		TLSClientConfig:   tlsConf.Clone(),
+		DialContext:       resolved.dialTCP,
+		ForceAttemptHTTP2: true,
+	}
+	defer h1.CloseIdleConnections()
+	defer h2.CloseIdleConnections()
+	clients := []struct {
+		name   string
+		client *http.Client
+	}{
+		{"HTTP/1.1", &http.Client{Transport: h1, Timeout: timeout}},
+		{"HTTP/2", &http.Client{Transport: h2, Timeout: timeout}},
+		{"HTTP/3", h3client},
+	}
+
+	log.Infof("%-9s %-10s %6s %10s %12s %12s %12s %12s", "protocol", "negotiated", "status", "bytes",
+		"first ttfb", "first total", "second ttfb", "second total")
+	for _, c := range clients {
+		first := fetchTimed(c.client, reqOpts, u)
+		if first.err != nil {
+			log.Errorf("%-9s failed: %v", c.name, first.err)
+			continue
+		}
+		second := fetchTimed(c.client, reqOpts, u)
+		if second.err != nil {
+			log.Errorf("%-9s second request failed: %v", c.name, second.err)
+			continue
+		}
+		log.Infof("%-9s %-10s %6d %10d %12v %12v %12v %12v", c.name, first.proto, first.status, first.bytes,
+			first.headers.Round(time.Microsecond), first.total.Round(time.Microsecond),
+			second.headers.Round(time.Microsecond), second.total.Round(time.Microsecond))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// urlFileKeepAlive is the keep-alive period of the connections with -url-file, so that they stay open between the URLs
+const urlFileKeepAlive = 10 * time.Second
+
+// pooledConn counts the requests sent on a connection: the round tripper opens a bidirectional
+// stream for each one, its control and QPACK streams being unidirectional
+type pooledConn struct {
+	quic.EarlyConnection
+	authority string
+	requests  atomic.Int64
+}
+
+func (c *pooledConn) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	str, err := c.EarlyConnection.OpenStreamSync(ctx)
+	if err == nil {
+		c.requests.Add(1)
+	}
+	return str, err
+}
+
+func (c *pooledConn) OpenStream() (quic.Stream, error) {
+	str, err := c.EarlyConnection.OpenStream()
+	if err == nil {
+		c.requests.Add(1)
+	}
+	return str, err
+}
+
+// connPool records the connections dialed by the round trippers, to report how the requests were coalesced on them
+type connPool struct {
+	mutex sync.Mutex
+	conns []*pooledConn
+}
+
+// dial wraps dialer to record its connections
+func (p *connPool) dial(dialer func(context.Context, string, *tls.Config, *quic.Config) (quic.EarlyConnection, error)) func(context.Context, string, *tls.Config, *quic.Config) (quic.EarlyConnection, error) {
+	return func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+		conn, err := dialer(ctx, addr, tlsCfg, cfg)
+		if err != nil {
+			return nil, err
+		}
+		pc := &pooledConn{EarlyConnection: conn, authority: addr}
+		p.mutex.Lock()
+		p.conns = append(p.conns, pc)
+		p.mutex.Unlock()
+		return pc, nil
+	}
+}
+
+// report logs the number of requests sent on each connection
+func (p *connPool) report() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.conns) == 0 {
+		return
+	}
+	var total int64
+	for i, conn := range p.conns {
+		n := conn.requests.Load()
+		total += n
+		log.Infof("Connection %d to %s (%s): %d requests", i+1, conn.authority, conn.RemoteAddr(), n)
+	}
+	log.Infof("%d requests on %d connections", total, len(p.conns))
+}
+
+// readURLs sends the URLs of file (- for stdin), one per line, as they are read. The empty lines
+// and those starting with # are skipped.
+func readURLs(file string, urls chan<- string) error {
+	defer close(urls)
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls <- line
+	}
+	return scanner.Err()
+}
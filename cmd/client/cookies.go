@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+type savedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+// persistentJar is a cookie jar saved to a file between runs.
+// It replays the cookies with the URL that set them, the matching rules are left to cookiejar.
+type persistentJar struct {
+	*cookiejar.Jar
+	path string
+
+	mutex   sync.Mutex
+	cookies map[string]savedCookie
+}
+
+func newPersistentJar(path string) (*persistentJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	j := &persistentJar{
+		Jar:     jar,
+		path:    path,
+		cookies: make(map[string]savedCookie),
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var saved []savedCookie
+	if err := json.Unmarshal(raw, &saved); err != nil {
+		return nil, err
+	}
+	for _, sc := range saved {
+		u, err := url.Parse(sc.URL)
+		if err != nil {
+			return nil, err
+		}
+		j.SetCookies(u, []*http.Cookie{sc.Cookie})
+	}
+	return j, nil
+}
+
+func (j *persistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for _, c := range cookies {
+		key := u.Host + "|" + c.Domain + "|" + c.Path + "|" + c.Name
+		j.cookies[key] = savedCookie{URL: u.String(), Cookie: c}
+	}
+}
+
+// save writes the cookies which are not expired yet to the jar file
+func (j *persistentJar) save() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := time.Now()
+	saved := []savedCookie{}
+	for _, sc := range j.cookies {
+		c := sc.Cookie
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now)) {
+			continue
+		}
+		saved = append(saved, sc)
+	}
+	raw, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(j.path, raw, 0600)
+}
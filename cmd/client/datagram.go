@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+	log "github.com/sirupsen/logrus"
+)
+
+// datagramEchoWait is how long the echoes are waited for once the last datagram is sent
+const datagramEchoWait = time.Second
+
+// runDatagrams sends count HTTP datagrams (RFC 9297) of size bytes, one every interval, along a request to the
+// /demo/datagram endpoint of the server at base, and reports how many were echoed: unlike the capsules of
+// -capsules, they are not retransmitted when lost
+func runDatagrams(hclient *http.Client, base string, count, size int, interval time.Duration) error {
+	if size < 8 {
+		return fmt.Errorf("datagrams of %d bytes, 8 at least are needed for their sequence number", size)
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	u := baseURL.ResolveReference(&url.URL{Path: "/demo/datagram", RawQuery: baseURL.RawQuery})
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	req, err := http.NewRequest(http.MethodPost, u.String(), pr)
+	if err != nil {
+		return err
+	}
+	// the response body must stay the one of the stream to get its connection
+	req.Header.Set("Accept-Encoding", "identity")
+	rsp, err := hclient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+		return fmt.Errorf("the datagram session was refused: %s %s", rsp.Status, msg)
+	}
+	h, ok := rsp.Body.(http3.Hijacker)
+	streamer, ok2 := rsp.Body.(http3.HTTPStreamer)
+	if !ok || !ok2 {
+		return fmt.Errorf("the datagram session requires HTTP/3")
+	}
+	conn, ok := h.StreamCreator().(quic.Connection)
+	if !ok || !conn.ConnectionState().SupportsDatagrams {
+		return fmt.Errorf("QUIC datagrams not negotiated with the server")
+	}
+	id := uint64(streamer.HTTPStream().StreamID()) / 4
+	log.Infof("Datagram session open on %s, sending %d datagrams of %d bytes", u, count, size)
+
+	var mutex sync.Mutex
+	sent := make([]time.Time, count)
+	rtts := make([]time.Duration, 0, count)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		for {
+			b, err := conn.ReceiveDatagram(ctx)
+			if err != nil {
+				return
+			}
+			r := bytes.NewReader(b)
+			quarterID, err := quicvarint.Read(r)
+			if err != nil || quarterID != id || r.Len() < 8 {
+				continue
+			}
+			seq := binary.BigEndian.Uint64(b[len(b)-r.Len():])
+			mutex.Lock()
+			if seq < uint64(count) && !sent[seq].IsZero() {
+				rtt := time.Since(sent[seq])
+				log.Debugf("datagram %d: echoed in %v", seq, rtt)
+				rtts = append(rtts, rtt)
+				sent[seq] = time.Time{}
+			}
+			mutex.Unlock()
+		}
+	}()
+
+	payload := make([]byte, size)
+	var dropped int
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		if _, err := rand.Read(payload[8:]); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(payload, uint64(i))
+		mutex.Lock()
+		sent[i] = time.Now()
+		mutex.Unlock()
+		if err := conn.SendDatagram(append(quicvarint.Append(nil, id), payload...)); err != nil {
+			// too large for a packet, or the send queue is full
+			log.Debugf("datagram %d: not sent: %v", i, err)
+			dropped++
+		}
+	}
+	time.Sleep(datagramEchoWait)
+	cancel()
+	<-received
+
+	// closing the request body ends the session, the server then sends its summary
+	pw.Close()
+	var summary struct {
+		Received int `json:"received"`
+		Echoed   int `json:"echoed"`
+		Dropped  int `json:"dropped"`
+	}
+	if err := json.NewDecoder(rsp.Body).Decode(&summary); err != nil {
+		return fmt.Errorf("reading the summary of the server: %w", err)
+	}
+
+	log.Infof("%d datagrams sent (%d not sent), %d received by the server, %d echoed (%d dropped), %d echoes received, %d lost",
+		count-dropped, dropped, summary.Received, summary.Echoed, summary.Dropped, len(rtts), count-len(rtts))
+	if len(rtts) == 0 {
+		return nil
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	log.Infof("Echo RTT: min %v, p50 %v, max %v", rtts[0], rtts[len(rtts)/2], rtts[len(rtts)-1])
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// connectionAttemptDelay is the delay before racing the next address (RFC 8305 section 5)
+const connectionAttemptDelay = 250 * time.Millisecond
+
+type dialResult struct {
+	conn quic.EarlyConnection
+	ip   net.IP
+	err  error
+}
+
+func addrFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// interleaveAddrs orders the addresses alternating the families, starting with IPv6 (RFC 8305 section 4)
+func interleaveAddrs(ips []net.IP) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			sorted = append(sorted, v6[i])
+		}
+		if i < len(v4) {
+			sorted = append(sorted, v4[i])
+		}
+	}
+	return sorted
+}
+
+func logGSO(conn quic.EarlyConnection) {
+	log.Infof("UDP segmentation offload (GSO) enabled for %s: %t", conn.RemoteAddr(), conn.ConnectionState().GSO)
+}
+
+// dialHappyEyeballs races QUIC handshakes to all the addresses of the host and returns
+// the first connection established, the other attempts are cancelled. The connections
+// share the sockets of transports.
+func dialHappyEyeballs(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := resolved.lookupIP(ctx, host, port)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 1 {
+		conn, err := transports.dial(ctx, ips[0], port, tlsCfg, cfg)
+		if err != nil {
+			return nil, err
+		}
+		logGSO(conn)
+		return conn, nil
+	}
+	ips = interleaveAddrs(ips)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, len(ips))
+	dial := func(ip net.IP) {
+		log.Debugf("Connecting to %s (%s)", ip, addrFamily(ip))
+		conn, err := transports.dial(ctx, ip, port, tlsCfg, cfg)
+		results <- dialResult{conn: conn, ip: ip, err: err}
+	}
+
+	next, pending := 0, 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var errs []error
+	for {
+		if next == len(ips) && pending == 0 {
+			return nil, errors.Join(errs...)
+		}
+		select {
+		case <-timer.C:
+			if next < len(ips) {
+				go dial(ips[next])
+				next++
+				pending++
+				timer.Reset(connectionAttemptDelay)
+			}
+		case res := <-results:
+			pending--
+			if res.err != nil {
+				log.Debugf("Connection to %s failed: %v", res.ip, res.err)
+				errs = append(errs, res.err)
+				// start the next attempt right away instead of waiting for the delay
+				if next < len(ips) {
+					timer.Reset(0)
+				}
+				continue
+			}
+			log.Infof("Connected to %s over %s", res.ip, addrFamily(res.ip))
+			logGSO(res.conn)
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					if late := <-results; late.err == nil {
+						late.conn.CloseWithError(0, "")
+					}
+				}
+			}(pending)
+			return res.conn, nil
+		}
+	}
+}
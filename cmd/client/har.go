@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HAR 1.2 structures, see http://www.softwareishard.com/blog/har-12-spec/
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+
+	started time.Time
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	values := []harNameValue{}
+	for name, vs := range h {
+		for _, v := range vs {
+			values = append(values, harNameValue{Name: name, Value: v})
+		}
+	}
+	return values
+}
+
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	values := []harNameValue{}
+	for _, c := range cookies {
+		values = append(values, harNameValue{Name: c.Name, Value: c.Value})
+	}
+	return values
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// harRecorder collects the timing and header data of the requests to write them as a HAR file.
+// The HTTP/3 round tripper does not report connection events, so the connection
+// setup is accounted in the wait timing and dns/connect/ssl are unknown (-1).
+type harRecorder struct {
+	mutex   sync.Mutex
+	entries []harEntry
+}
+
+// add records rsp, whose headers were received at headersAt and body (of bodySize bytes) fully read at end
+func (h *harRecorder) add(rsp *http.Response, bodySize int64, start, headersAt, end time.Time) {
+	req := rsp.Request
+	query := []harNameValue{}
+	for name, vs := range req.URL.Query() {
+		for _, v := range vs {
+			query = append(query, harNameValue{Name: name, Value: v})
+		}
+	}
+	reqBodySize := req.ContentLength
+	if req.Body == nil {
+		reqBodySize = 0
+	}
+
+	entry := harEntry{
+		StartedDateTime: start.Format(time.RFC3339Nano),
+		started:         start,
+		Time:            milliseconds(end.Sub(start)),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: rsp.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: query,
+			HeadersSize: -1,
+			BodySize:    reqBodySize,
+		},
+		Response: harResponse{
+			Status:      rsp.StatusCode,
+			StatusText:  http.StatusText(rsp.StatusCode),
+			HTTPVersion: rsp.Proto,
+			Cookies:     harCookies(rsp.Cookies()),
+			Headers:     harHeaders(rsp.Header),
+			Content: harContent{
+				Size:     bodySize,
+				MimeType: rsp.Header.Get("Content-Type"),
+			},
+			RedirectURL: rsp.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    bodySize,
+		},
+		Timings: harTimings{
+			Blocked: -1,
+			DNS:     -1,
+			Connect: -1,
+			Send:    0,
+			Wait:    milliseconds(headersAt.Sub(start)),
+			Receive: milliseconds(end.Sub(headersAt)),
+			SSL:     -1,
+		},
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.entries = append(h.entries, entry)
+}
+
+// write saves the recorded entries, sorted by start time, in the HAR file at path
+func (h *harRecorder) write(path string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	sort.Slice(h.entries, func(i, j int) bool { return h.entries[i].started.Before(h.entries[j].started) })
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Log harLog `json:"log"`
+	}{
+		Log: harLog{
+			Version: "1.2",
+			Creator: harCreator{Name: "quicgo-client", Version: VERSION},
+			Entries: h.entries,
+		},
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// localBinding is the source of the UDP sockets of the client given with -local-addr or -interface
+type localBinding struct {
+	ip    net.IP
+	port  int
+	iface *net.Interface
+}
+
+// source is the binding of the UDP sockets, nil to let the system choose
+var source *localBinding
+
+// parseLocalBinding returns the binding of -local-addr (IP or IP:port) or -interface, nil when none is given
+func parseLocalBinding(addr, iface string) (*localBinding, error) {
+	if len(addr) > 0 && len(iface) > 0 {
+		return nil, fmt.Errorf("-local-addr and -interface are mutually exclusive")
+	}
+	if len(iface) > 0 {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, err
+		}
+		return &localBinding{iface: ifi}, nil
+	}
+	if len(addr) == 0 {
+		return nil, nil
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		return &localBinding{ip: ip}, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -local-addr %s, expected IP or IP:port", addr)
+	}
+	ip := net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid -local-addr %s, expected IP or IP:port", addr)
+	}
+	return &localBinding{ip: ip, port: p}, nil
+}
+
+func matchesNetwork(ip net.IP, network string) bool {
+	switch network {
+	case "udp4":
+		return ip.To4() != nil
+	case "udp6":
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// listenAddr returns the address a socket of network (udp, udp4 or udp6) is bound to: the address
+// of -local-addr, or the first one of the family on the interface, link-local ones excluded
+func (b *localBinding) listenAddr(network string) (*net.UDPAddr, error) {
+	if b == nil {
+		return nil, nil
+	}
+	if b.ip != nil {
+		if !matchesNetwork(b.ip, network) {
+			return nil, fmt.Errorf("the local address %s can't reach the %s servers", b.ip, network)
+		}
+		return &net.UDPAddr{IP: b.ip, Port: b.port}, nil
+	}
+	addrs, err := b.iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && matchesNetwork(ipNet.IP, network) && !ipNet.IP.IsLinkLocalUnicast() {
+			return &net.UDPAddr{IP: ipNet.IP}, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s address on the interface %s", network, b.iface.Name)
+}
+
+// rebindAddr returns the address of a new socket of the rebinding test, on another port of the same IP
+func (b *localBinding) rebindAddr(current net.Addr) *net.UDPAddr {
+	if b == nil {
+		return nil
+	}
+	return &net.UDPAddr{IP: current.(*net.UDPAddr).IP}
+}
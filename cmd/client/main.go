@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
@@ -40,13 +41,52 @@ func (h bufferedWriteCloser) Close() error {
 	return h.Closer.Close()
 }
 
+var (
+	VERSION = "0.1.0"
+)
+
 func main() {
 	verbose := flag.Bool("v", false, "verbose")
 	quiet := flag.Bool("q", false, "don't print the data")
 	keyLogFile := flag.String("keylog", "", "key log file")
 	insecure := flag.Bool("insecure", false, "skip certificate verification")
+	localAddr := flag.String("local-addr", "", "Bind the UDP sockets to this source IP or IP:port, for the multihomed machines")
+	iface := flag.String("interface", "", "Bind the UDP sockets to the first address of this network interface, of each address family")
+	rebindAfter := flag.Duration("rebind-after", 0, "Download the URL and move to a new UDP port after this delay, like a NAT rebinding, exiting with an error unless the transfer survives it")
+	socksListen := flag.String("socks-listen", "", "Forward the SOCKS5 connections accepted on this local address to the quicgo-socks5 gateway of the server of the URL")
 	caCertFile := flag.String("ca-cert", "", "Path to the CA cert file")
+	certFile := flag.String("cert", "", "Path to the client cert file, for the servers requiring mTLS")
+	keyFile := flag.String("key", "", "Path to the key file of -cert")
 	enableQlog := flag.Bool("qlog", false, "output a qlog (in the same directory)")
+	qlogEvents := flag.String("qlog-events", "", "Comma separated qlog event categories to record among transport,security,recovery (defaults to all)")
+	method := flag.String("X", "", "Request method (defaults to GET, or POST when a body is given)")
+	reqHeaders := headers{}
+	flag.Var(&reqHeaders, "H", "Extra request header \"Name: value\", can be repeated")
+	flag.Var(resolved, "resolve", "Connect to these addresses for host:port, \"host:port:addr[,addr...]\" like curl, keeping host for the SNI and the Host header, can be repeated")
+	data := flag.String("d", "", "Request body")
+	dataFile := flag.String("data-file", "", "Read the request body from this file (- for stdin)")
+	urlFile := flag.String("url-file", "", "Also fetch the URLs of this file (- for stdin), one per line, as they are read, reusing the connections of the previous requests")
+	output := flag.String("output", "", "Write the response body to this file instead of printing it (single URL only)")
+	maxTime := flag.Duration("max-time", 0, "Maximum time allowed for each request (0 means no limit)")
+	retries := flag.Int("retries", 0, "Retry the idempotent requests failing with a timeout or a connection error this number of times, on a new connection")
+	retryBackoff := flag.Duration("retry-backoff", 500*time.Millisecond, "Wait before the first retry, doubled on each one")
+	followRedirects := flag.Bool("L", false, "Follow 3xx redirects")
+	maxRedirs := flag.Int("max-redirs", 10, "Maximum number of redirects followed with -L")
+	cookieJarFile := flag.String("cookie-jar", "", "Load cookies from and save them to this file")
+	harFile := flag.String("har", "", "Write the timing and header data of the requests to this HAR file")
+	pingCount := flag.Int("ping", 0, "Sample the /ping endpoint of the server of the first URL this number of times and print RTT percentiles")
+	pingInterval := flag.Duration("ping-interval", 100*time.Millisecond, "Interval between two ping samples")
+	multistream := flag.Int("multistream", 0, "Run the multistream benchmark with this number of parallel streams against the server of the first URL")
+	multistreamSize := flag.Int64("multistream-size", 1<<20, "Size in bytes of each stream of the multistream benchmark")
+	capsules := flag.Int("capsules", 0, "Open a capsule protocol session on the /demo/capsules endpoint of the server of the first URL and send this number of DATAGRAM capsules, checking they are sent back")
+	capsuleSize := flag.Int("capsule-size", 1024, "Size in bytes of the DATAGRAM capsules of -capsules")
+	datagrams := flag.Int("datagrams", 0, "Send this number of HTTP datagrams along a request to the /demo/datagram endpoint of the server of the first URL and report how many are echoed")
+	datagramSize := flag.Int("datagram-size", 512, "Size in bytes of the datagrams of -datagrams, they must fit in a QUIC packet")
+	datagramInterval := flag.Duration("datagram-interval", 10*time.Millisecond, "Interval between two datagrams of -datagrams")
+	disableECN := flag.Bool("disable-ecn", false, "Disable ECN marking and validation on the UDP sockets")
+	disableGSO := flag.Bool("disable-gso", false, "Disable the UDP segmentation offload (GSO), broken in some virtualized environments")
+	disablePMTUD := flag.Bool("disable-pmtud", false, "Disable the Path MTU Discovery, for paths with a small MTU")
+	compare := flag.Bool("compare", false, "Fetch the first URL over HTTP/1.1, HTTP/2 and HTTP/3 and print a timing table")
 	flag.Parse()
 	urls := flag.Args()
 
@@ -56,6 +96,13 @@ func main() {
 	} else {
 		log.SetLevel(log.InfoLevel)
 	}
+	if *disableECN {
+		// read by quic-go when it sets up the UDP sockets
+		os.Setenv("QUIC_GO_DISABLE_ECN", "true")
+	}
+	if *disableGSO {
+		os.Setenv("QUIC_GO_DISABLE_GSO", "true")
+	}
 
 	var keyLog io.Writer
 	if len(*keyLogFile) > 0 {
@@ -71,6 +118,9 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if source, err = parseLocalBinding(*localAddr, *iface); err != nil {
+		log.Fatal(err)
+	}
 
 	if _, err := os.Stat(*caCertFile); os.IsNotExist(err) {
 		log.Debugf("CA Cert file %s not exit", *caCertFile)
@@ -84,48 +134,216 @@ func main() {
 		}
 	}
 
-	var qconf quic.Config
+	var certs []tls.Certificate
+	if len(*certFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatalf("Unable to load the client cert/key files: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	events, err := parseQlogEvents(*qlogEvents)
+	if err != nil {
+		log.Fatal(err)
+	}
+	qconf := quic.Config{
+		DisablePathMTUDiscovery: *disablePMTUD,
+	}
+	if len(*urlFile) > 0 {
+		// the next URL may take a while to come from stdin
+		qconf.KeepAlivePeriod = urlFileKeepAlive
+	}
+	var tracers []func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer
 	if *enableQlog {
-		qconf.Tracer = func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+		tracers = append(tracers, func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
 			filename := fmt.Sprintf("client_%x.qlog", connID)
 			f, err := os.Create(filename)
 			if err != nil {
 				log.Fatal(err)
 			}
 			log.Infof("Creating qlog file %s.\n", filename)
-			return qlog.NewConnectionTracer(NewBufferedWriteCloser(bufio.NewWriter(f), f), p, connID)
-		}
+			return filterQlogEvents(qlog.NewConnectionTracer(NewBufferedWriteCloser(bufio.NewWriter(f), f), p, connID), events)
+		})
+	}
+	rtt := &transportRTT{}
+	if *pingCount > 0 {
+		tracers = append(tracers, rtt.tracer)
 	}
-	roundTripper := &http3.RoundTripper{
-		TLSClientConfig: &tls.Config{
-			RootCAs:            pool,
-			InsecureSkipVerify: *insecure,
-			KeyLogWriter:       keyLog,
-		},
-		QuicConfig: &qconf,
+	qconf.Tracer = newMultiplexedTracer(tracers...)
+	tlsConf := &tls.Config{
+		RootCAs:            pool,
+		Certificates:       certs,
+		InsecureSkipVerify: *insecure,
+		KeyLogWriter:       keyLog,
 	}
+	conns := &connPool{}
+	newRoundTripper := func() *http3.RoundTripper {
+		return &http3.RoundTripper{
+			TLSClientConfig: tlsConf,
+			QuicConfig:      &qconf,
+			Dial:            conns.dial(dialHappyEyeballs),
+			EnableDatagrams: *datagrams > 0,
+		}
+	}
+	// closed after the round trippers, their connections share its sockets
+	defer transports.close()
+	roundTripper := newRoundTripper()
 	defer roundTripper.Close()
 	hclient := &http.Client{
 		Transport: roundTripper,
+		Timeout:   *maxTime,
 	}
 
+	hclient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !*followRedirects {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > *maxRedirs {
+			return fmt.Errorf("stopped after %d redirects", *maxRedirs)
+		}
+		log.Infof("Redirected to %s", req.URL)
+		return nil
+	}
+	var jar *persistentJar
+	if len(*cookieJarFile) > 0 {
+		if jar, err = newPersistentJar(*cookieJarFile); err != nil {
+			log.Fatalf("Unable to load cookie jar: %v", err)
+		}
+		hclient.Jar = jar
+	}
+
+	reqBody, err := loadRequestBody(*data, *dataFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reqOpts := &requestOptions{
+		method:  *method,
+		headers: reqHeaders,
+		body:    reqBody,
+	}
+	if len(*urlFile) > 0 && *urlFile == *dataFile {
+		log.Fatal("-url-file and -data-file can't both read stdin")
+	}
+	if len(*output) > 0 && (len(urls) != 1 || len(*urlFile) > 0) {
+		log.Fatal("-output requires a single URL")
+	}
+
+	if *pingCount > 0 {
+		if len(urls) == 0 {
+			log.Fatal("The ping mode requires the URL of the server")
+		}
+		if err := runPing(hclient, urls[0], *pingCount, *pingInterval, rtt); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *multistream > 0 {
+		if len(urls) == 0 {
+			log.Fatal("The multistream benchmark requires the URL of the server")
+		}
+		if err := runMultistream(hclient, urls[0], *multistream, *multistreamSize); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *capsules > 0 {
+		if len(urls) == 0 {
+			log.Fatal("The capsules mode requires the URL of the server")
+		}
+		if err := runCapsules(hclient, urls[0], *capsules, *capsuleSize); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *datagrams > 0 {
+		if len(urls) == 0 {
+			log.Fatal("The datagrams mode requires the URL of the server")
+		}
+		if err := runDatagrams(hclient, urls[0], *datagrams, *datagramSize, *datagramInterval); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *rebindAfter > 0 {
+		if len(urls) != 1 {
+			log.Fatal("The rebinding test requires a single URL, e.g. https://host/bench/download?duration=5s")
+		}
+		if err := runRebindTest(urls[0], roundTripper.TLSClientConfig, &qconf, *rebindAfter); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(*socksListen) > 0 {
+		if len(urls) == 0 {
+			log.Fatal("The SOCKS tunnel requires the URL of the server")
+		}
+		if err := runSOCKSTunnel(*socksListen, urls[0], roundTripper.TLSClientConfig, &qconf); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *compare {
+		if len(urls) == 0 {
+			log.Fatal("The compare mode requires a URL")
+		}
+		runCompare(hclient, reqOpts, urls[0], roundTripper.TLSClientConfig, *maxTime)
+		return
+	}
+
+	retry := &retryPolicy{retries: *retries, backoff: *retryBackoff, newTransport: newRoundTripper}
+	har := &harRecorder{}
 	var wg sync.WaitGroup
-	wg.Add(len(urls))
-	for _, addr := range urls {
-		log.Infof("GET %s", addr)
+	// fetch sends the request of addr in the background, on the connection of its server if one is open
+	fetch := func(addr string) {
+		req, err := reqOpts.newRequest(addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("%s %s", req.Method, addr)
+		wg.Add(1)
 		go func(addr string) {
-			rsp, err := hclient.Get(addr)
-			if err != nil {
-				log.Fatal(err)
-			}
-			log.Infof("Got response for %s: %#v", addr, rsp)
-
+			var rsp *http.Response
+			var start, headersAt time.Time
 			body := &bytes.Buffer{}
-			_, err = io.Copy(body, rsp.Body)
+			attempts, err := retry.do(hclient, req.Method, func(client *http.Client) error {
+				req, err := reqOpts.newRequest(addr)
+				if err != nil {
+					return err
+				}
+				body.Reset()
+				start = time.Now()
+				if rsp, err = client.Do(req); err != nil {
+					return err
+				}
+				defer rsp.Body.Close()
+				headersAt = time.Now()
+				log.Infof("Got response for %s: %#v", addr, rsp)
+				_, err = io.Copy(body, rsp.Body)
+				return err
+			})
+			if err != nil && *retries > 0 {
+				log.Fatalf("%v, after %d attempts", err, attempts)
+			}
 			if err != nil {
 				log.Fatal(err)
 			}
-			if *quiet {
+			if *retries > 0 {
+				log.Infof("%s %s took %d attempts", req.Method, addr, attempts)
+			}
+			har.add(rsp, int64(body.Len()), start, headersAt, time.Now())
+			if len(*output) > 0 {
+				if err := os.WriteFile(*output, body.Bytes(), 0644); err != nil {
+					log.Fatal(err)
+				}
+				log.Infof("Response Body: %d bytes written to %s", body.Len(), *output)
+			} else if *quiet {
 				log.Infof("Request Body: %d bytes", body.Len())
 			} else {
 				log.Infof("Request Body:")
@@ -134,5 +352,32 @@ func main() {
 			wg.Done()
 		}(addr)
 	}
+	for _, addr := range urls {
+		fetch(addr)
+	}
+	if len(*urlFile) > 0 {
+		fileURLs := make(chan string)
+		errc := make(chan error, 1)
+		go func() { errc <- readURLs(*urlFile, fileURLs) }()
+		for addr := range fileURLs {
+			fetch(addr)
+		}
+		if err := <-errc; err != nil {
+			log.Fatalf("Unable to read the URLs of %s: %v", *urlFile, err)
+		}
+	}
 	wg.Wait()
+	conns.report()
+
+	if jar != nil {
+		if err := jar.save(); err != nil {
+			log.Fatalf("Unable to save cookie jar: %v", err)
+		}
+	}
+	if len(*harFile) > 0 {
+		if err := har.write(*harFile); err != nil {
+			log.Fatalf("Unable to write HAR file: %v", err)
+		}
+		log.Infof("HAR written to %s", *harFile)
+	}
 }
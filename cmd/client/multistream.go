@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type streamResult struct {
+	Stream         int     `json:"stream"`
+	Bytes          int64   `json:"bytes"`
+	StartSeconds   float64 `json:"start_seconds"`
+	EndSeconds     float64 `json:"end_seconds"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	GoodputMbps    float64 `json:"goodput_mbps"`
+}
+
+type multistreamReport struct {
+	Session         string         `json:"session"`
+	Streams         int            `json:"streams"`
+	Complete        bool           `json:"complete"`
+	Results         []streamResult `json:"results"`
+	TotalBytes      int64          `json:"total_bytes"`
+	CompletionTime  float64        `json:"completion_seconds"`
+	AggregateMbps   float64        `json:"aggregate_goodput_mbps"`
+	FairnessJainIdx float64        `json:"fairness_jain_index"`
+}
+
+// runMultistream opens streams parallel requests of size bytes on the connection to the server
+// at base, all tagged with the same session, then prints the report computed by the server
+func runMultistream(hclient *http.Client, base string, streams int, size int64) error {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	session := hex.EncodeToString(id)
+
+	log.Infof("Multistream session %s: %d streams of %d bytes", session, streams, size)
+	clientTimes := make([]time.Duration, streams)
+	var wg sync.WaitGroup
+	wg.Add(streams)
+	start := time.Now()
+	for i := 0; i < streams; i++ {
+		go func(i int) {
+			defer wg.Done()
+			u := baseURL.ResolveReference(&url.URL{
+				Path:     "/bench/multistream",
+				RawQuery: fmt.Sprintf("session=%s&streams=%d&stream=%d&size=%d", session, streams, i, size),
+			})
+			rsp, err := hclient.Get(u.String())
+			if err != nil {
+				log.Errorf("Stream %d failed: %v", i, err)
+				return
+			}
+			defer rsp.Body.Close()
+			if _, err := io.Copy(io.Discard, rsp.Body); err != nil {
+				log.Errorf("Stream %d failed: %v", i, err)
+				return
+			}
+			clientTimes[i] = time.Since(start)
+		}(i)
+	}
+	wg.Wait()
+
+	u := baseURL.ResolveReference(&url.URL{Path: "/bench/multistream/report", RawQuery: "session=" + session})
+	rsp, err := hclient.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("report request failed: %s", rsp.Status)
+	}
+	var report multistreamReport
+	if err := json.NewDecoder(rsp.Body).Decode(&report); err != nil {
+		return err
+	}
+
+	for _, res := range report.Results {
+		log.Infof("stream %3d: %d bytes, server start %.3fs end %.3fs (%.2f Mbit/s), client completion %.3fs",
+			res.Stream, res.Bytes, res.StartSeconds, res.EndSeconds, res.GoodputMbps, clientTimes[res.Stream].Seconds())
+	}
+	if !report.Complete {
+		log.Warnf("Only %d of %d streams completed", len(report.Results), report.Streams)
+	}
+	log.Infof("Total: %d bytes in %.3fs, aggregate %.2f Mbit/s, Jain fairness index %.3f",
+		report.TotalBytes, report.CompletionTime, report.AggregateMbps, report.FairnessJainIdx)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+type pingResult struct {
+	Received int64 `json:"server_received_ns"`
+	Sent     int64 `json:"server_sent_ns"`
+}
+
+// transportRTT keeps the last RTT estimates of the QUIC loss recovery
+type transportRTT struct {
+	mutex    sync.Mutex
+	smoothed time.Duration
+	min      time.Duration
+	latest   time.Duration
+}
+
+func (t *transportRTT) tracer(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer {
+	return &logging.ConnectionTracer{
+		UpdatedMetrics: func(rttStats *logging.RTTStats, cwnd, bytesInFlight logging.ByteCount, packetsInFlight int) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			t.smoothed = rttStats.SmoothedRTT()
+			t.min = rttStats.MinRTT()
+			t.latest = rttStats.LatestRTT()
+		},
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
+
+func ping(hclient *http.Client, u string) (time.Duration, time.Duration, error) {
+	start := time.Now()
+	rsp, err := hclient.Get(u)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rsp.Body.Close()
+	var res pingResult
+	if err := json.NewDecoder(rsp.Body).Decode(&res); err != nil {
+		return 0, 0, err
+	}
+	return time.Since(start), time.Duration(res.Sent - res.Received), nil
+}
+
+// runPing samples the /ping endpoint of the server at base count times and prints the RTT percentiles.
+// The application RTT excludes the server processing time reported in the response.
+func runPing(hclient *http.Client, base string, count int, interval time.Duration, rtt *transportRTT) error {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	u := baseURL.ResolveReference(&url.URL{Path: "/ping"}).String()
+
+	// the first request pays for the handshake, don't count it
+	if _, _, err := ping(hclient, u); err != nil {
+		return err
+	}
+
+	samples := make([]time.Duration, 0, count)
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		total, processing, err := ping(hclient, u)
+		if err != nil {
+			return err
+		}
+		appRTT := total - processing
+		log.Debugf("ping %d: application RTT %v (server processing %v)", i, appRTT, processing)
+		samples = append(samples, appRTT)
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	log.Infof("Application RTT over %d samples: min %v, p50 %v, p90 %v, p99 %v, max %v",
+		len(samples), samples[0], percentile(samples, 50), percentile(samples, 90), percentile(samples, 99), samples[len(samples)-1])
+	rtt.mutex.Lock()
+	defer rtt.mutex.Unlock()
+	log.Infof("Transport RTT: smoothed %v, min %v, latest %v", rtt.smoothed, rtt.min, rtt.latest)
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/quic-go/quic-go/logging"
+)
+
+// qlogCategories are the qlog event categories which can be selected with -qlog-events,
+// named like the prefixes of the event names written by quic-go
+var qlogCategories = []string{"transport", "security", "recovery"}
+
+// parseQlogEvents parses a comma separated list of qlog event categories, an empty list selects all of them
+func parseQlogEvents(v string) (map[string]bool, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	events := make(map[string]bool)
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		known := false
+		for _, c := range qlogCategories {
+			known = known || c == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown qlog event category %s, expected one of %s", name, strings.Join(qlogCategories, ","))
+		}
+		events[name] = true
+	}
+	return events, nil
+}
+
+// filterQlogEvents removes from the qlog tracer t the events of the categories not in events
+func filterQlogEvents(t *logging.ConnectionTracer, events map[string]bool) *logging.ConnectionTracer {
+	if events == nil {
+		return t
+	}
+	if !events["transport"] {
+		t.StartedConnection = nil
+		t.ClosedConnection = nil
+		t.NegotiatedVersion = nil
+		t.SentTransportParameters = nil
+		t.ReceivedTransportParameters = nil
+		t.RestoredTransportParameters = nil
+		t.SentLongHeaderPacket = nil
+		t.SentShortHeaderPacket = nil
+		t.ReceivedVersionNegotiationPacket = nil
+		t.ReceivedRetry = nil
+		t.ReceivedLongHeaderPacket = nil
+		t.ReceivedShortHeaderPacket = nil
+		t.BufferedPacket = nil
+		t.DroppedPacket = nil
+	}
+	if !events["security"] {
+		t.UpdatedKeyFromTLS = nil
+		t.UpdatedKey = nil
+		t.DroppedEncryptionLevel = nil
+		t.DroppedKey = nil
+	}
+	if !events["recovery"] {
+		t.UpdatedMetrics = nil
+		t.AcknowledgedPacket = nil
+		t.LostPacket = nil
+		t.UpdatedCongestionState = nil
+		t.UpdatedPTOCount = nil
+		t.SetLossTimer = nil
+		t.LossTimerExpired = nil
+		t.LossTimerCanceled = nil
+		t.ECNStateUpdated = nil
+	}
+	return t
+}
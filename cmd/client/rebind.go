@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	log "github.com/sirupsen/logrus"
+)
+
+// rebindStallTimeout is how long the transfer may make no progress before it is declared dead
+const rebindStallTimeout = 5 * time.Second
+
+// rebindingConn is a UDP socket which can be swapped for a new one, like a NAT rebinding
+// the client port, while the QUIC connection keeps using it
+type rebindingConn struct {
+	mutex  sync.Mutex
+	conn   *net.UDPConn
+	closed bool
+
+	readBuffer, writeBuffer int // socket buffer sizes set by quic-go, applied to the new sockets too
+}
+
+func newRebindingConn() (*rebindingConn, error) {
+	laddr, err := source.listenAddr("udp")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &rebindingConn{conn: conn}, nil
+}
+
+func (c *rebindingConn) current() *net.UDPConn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn
+}
+
+// rebind moves to a new socket, on a new port, and closes the previous one
+func (c *rebindingConn) rebind() (net.Addr, net.Addr, error) {
+	conn, err := net.ListenUDP("udp", source.rebindAddr(c.LocalAddr()))
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		conn.Close()
+		return nil, nil, net.ErrClosed
+	}
+	if c.readBuffer > 0 {
+		conn.SetReadBuffer(c.readBuffer)
+	}
+	if c.writeBuffer > 0 {
+		conn.SetWriteBuffer(c.writeBuffer)
+	}
+	old := c.conn
+	c.conn = conn
+	c.mutex.Unlock()
+	old.Close()
+	return old.LocalAddr(), conn.LocalAddr(), nil
+}
+
+func (c *rebindingConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		conn := c.current()
+		n, addr, err := conn.ReadFrom(p)
+		if err != nil && conn != c.current() {
+			// closed by rebind, read from the new socket
+			continue
+		}
+		return n, addr, err
+	}
+}
+
+func (c *rebindingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.current().WriteTo(p, addr)
+}
+
+func (c *rebindingConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	return c.conn.Close()
+}
+
+func (c *rebindingConn) LocalAddr() net.Addr {
+	return c.current().LocalAddr()
+}
+
+func (c *rebindingConn) SetReadBuffer(bytes int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readBuffer = bytes
+	return c.conn.SetReadBuffer(bytes)
+}
+
+func (c *rebindingConn) SetWriteBuffer(bytes int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writeBuffer = bytes
+	return c.conn.SetWriteBuffer(bytes)
+}
+
+func (c *rebindingConn) SetDeadline(t time.Time) error {
+	return c.current().SetDeadline(t)
+}
+
+func (c *rebindingConn) SetReadDeadline(t time.Time) error {
+	return c.current().SetReadDeadline(t)
+}
+
+func (c *rebindingConn) SetWriteDeadline(t time.Time) error {
+	return c.current().SetWriteDeadline(t)
+}
+
+// runRebindTest downloads u and rebinds the UDP socket of the connection after the delay given,
+// to check whether the server handles the NAT rebinding by validating the new path. It fails when
+// the transfer does not complete after the rebinding, or with less data than announced by the server.
+func runRebindTest(u string, tlsConf *tls.Config, conf *quic.Config, after time.Duration) error {
+	conn, err := newRebindingConn()
+	if err != nil {
+		return err
+	}
+	tr := &quic.Transport{Conn: conn}
+	defer tr.Close()
+	roundTripper := &http3.RoundTripper{
+		TLSClientConfig: tlsConf,
+		QuicConfig:      conf,
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			ips, err := resolved.lookupIP(ctx, host, port)
+			if err != nil {
+				return nil, err
+			}
+			udpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ips[0].String(), port))
+			if err != nil {
+				return nil, err
+			}
+			return tr.DialEarly(ctx, udpAddr, tlsCfg, cfg)
+		},
+	}
+	defer roundTripper.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	rsp, err := (&http.Client{Transport: roundTripper}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", u, rsp.Status)
+	}
+
+	rebound := make(chan struct{})
+	var rebindErr error
+	timer := time.AfterFunc(after, func() {
+		defer close(rebound)
+		from, to, err := conn.rebind()
+		if err != nil {
+			rebindErr = fmt.Errorf("unable to rebind the UDP socket: %w", err)
+			return
+		}
+		log.Infof("Rebound the UDP socket from %s to %s after %v", from, to, time.Since(start).Round(time.Millisecond))
+	})
+	defer timer.Stop()
+
+	// cancel the request once nothing is received for a while, without migration quic-go would wait for the idle timeout
+	var received, afterRebind int64
+	buf := make([]byte, 32<<10)
+	stall := time.AfterFunc(rebindStallTimeout, cancel)
+	defer stall.Stop()
+	for {
+		n, err := rsp.Body.Read(buf)
+		if n > 0 {
+			stall.Reset(rebindStallTimeout)
+			received += int64(n)
+			select {
+			case <-rebound:
+				afterRebind += int64(n)
+			default:
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				err = fmt.Errorf("no data received for %v", rebindStallTimeout)
+			}
+			return fmt.Errorf("transfer did not survive the rebinding: %d bytes received, %d after the rebinding: %w", received, afterRebind, err)
+		}
+	}
+	select {
+	case <-rebound:
+	default:
+		return fmt.Errorf("transfer completed in %v before the rebinding, use a longer one or a shorter -rebind-after", time.Since(start).Round(time.Millisecond))
+	}
+	if rebindErr != nil {
+		return rebindErr
+	}
+	// the trailer of /bench/download, only received over TCP
+	expected := rsp.ContentLength
+	if n, err := strconv.ParseInt(rsp.Trailer.Get("X-Bench-Bytes"), 10, 64); err == nil {
+		expected = n
+	}
+	if expected >= 0 && received != expected {
+		return fmt.Errorf("transfer truncated by the rebinding: %d bytes received, %d sent", received, expected)
+	}
+	log.Infof("Transfer survived the rebinding: %d bytes received in %v, %d after the rebinding", received, time.Since(start).Round(time.Millisecond), afterRebind)
+	return nil
+}
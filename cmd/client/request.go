@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// headers collects the repeated -H "Name: value" options
+type headers []string
+
+func (h *headers) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headers) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("invalid header %q, expected \"Name: value\"", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+// requestOptions holds the curl-style options used to build every request
+type requestOptions struct {
+	method  string
+	headers headers
+	body    []byte // nil when the request has no body
+}
+
+// loadRequestBody returns the request body given with -d or -data-file
+func loadRequestBody(data, dataFile string) ([]byte, error) {
+	if len(data) > 0 && len(dataFile) > 0 {
+		return nil, fmt.Errorf("-d and -data-file are mutually exclusive")
+	}
+	if len(dataFile) > 0 {
+		if dataFile == "-" {
+			return io.ReadAll(os.Stdin)
+		}
+		return os.ReadFile(dataFile)
+	}
+	if len(data) > 0 {
+		return []byte(data), nil
+	}
+	return nil, nil
+}
+
+func (o *requestOptions) newRequest(url string) (*http.Request, error) {
+	method := o.method
+	if len(method) == 0 {
+		method = http.MethodGet
+		if o.body != nil {
+			method = http.MethodPost
+		}
+	}
+
+	var body io.Reader
+	if o.body != nil {
+		body = bytes.NewReader(o.body)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if o.body != nil {
+		// same default as curl
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, h := range o.headers {
+		name, value, _ := strings.Cut(h, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
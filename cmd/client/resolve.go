@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// resolveOverrides collects the repeated curl-style -resolve host:port:addr[,addr...] options: the
+// connections to host:port go to these addresses, the SNI and the Host header still being host
+type resolveOverrides map[string][]net.IP
+
+// resolved are the -resolve overrides used by all the dialers of the client
+var resolved = resolveOverrides{}
+
+func (r resolveOverrides) String() string {
+	var s []string
+	for hostport, ips := range r {
+		for _, ip := range ips {
+			s = append(s, hostport+":"+ip.String())
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+func (r resolveOverrides) Set(v string) error {
+	// the host can't hold a colon, unlike the IPv6 addresses
+	host, rest, ok := strings.Cut(v, ":")
+	port, addrs, ok2 := strings.Cut(rest, ":")
+	if !ok || !ok2 || len(host) == 0 || len(port) == 0 {
+		return fmt.Errorf("invalid resolve %q, expected host:port:addr", v)
+	}
+	hostport := net.JoinHostPort(strings.ToLower(host), port)
+	for _, addr := range strings.Split(addrs, ",") {
+		ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+		if ip == nil {
+			return fmt.Errorf("invalid address %q in resolve %q", addr, v)
+		}
+		r[hostport] = append(r[hostport], ip)
+	}
+	return nil
+}
+
+// lookupIP returns the addresses of host, those given with -resolve for host:port if any
+func (r resolveOverrides) lookupIP(ctx context.Context, host, port string) ([]net.IP, error) {
+	if ips, ok := r[net.JoinHostPort(strings.ToLower(host), port)]; ok {
+		return ips, nil
+	}
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// dialTCP dials addr like net.Dialer.DialContext, to the first address given with -resolve for it if any
+func (r resolveOverrides) dialTCP(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ips, ok := r[net.JoinHostPort(strings.ToLower(host), port)]; ok {
+		addr = net.JoinHostPort(ips[0].String(), port)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
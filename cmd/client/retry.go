@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	log "github.com/sirupsen/logrus"
+)
+
+// retryPolicy retries the idempotent requests failing with a timeout or a connection error
+type retryPolicy struct {
+	retries int
+	backoff time.Duration // wait before the first retry, doubled on each one
+	// newTransport creates the round tripper of a retry, dialing a new QUIC connection
+	newTransport func() *http3.RoundTripper
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isRetryable tells whether err is a timeout or the loss of the connection, which a new attempt may not hit
+func isRetryable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		// including the handshake and idle timeouts of QUIC
+		return true
+	}
+	var transportErr *quic.TransportError
+	if errors.As(err, &transportErr) {
+		// the TLS failures would happen again
+		return !transportErr.ErrorCode.IsCryptoError()
+	}
+	// the round tripper returns the same error for the streams and the connections closed with an HTTP/3 code
+	var h3Err *http3.Error
+	if errors.As(err, &h3Err) {
+		switch h3Err.ErrorCode {
+		case http3.ErrCodeNoError, http3.ErrCodeInternalError, http3.ErrCodeExcessiveLoad:
+			return true
+		case http3.ErrCodeRequestRejected:
+			// not processed by the server (RFC 9114 section 4.1.1)
+			return true
+		}
+		return false
+	}
+	var resetErr *quic.StatelessResetError
+	return errors.As(err, &resetErr) || errors.Is(err, net.ErrClosed)
+}
+
+// do runs attempt with client, then again with a new connection as long as it fails with a retryable error.
+// It returns the number of attempts made.
+func (p *retryPolicy) do(client *http.Client, method string, attempt func(*http.Client) error) (int, error) {
+	err := attempt(client)
+	for n := 1; ; n++ {
+		if err == nil || n > p.retries || !isIdempotent(method) || !isRetryable(err) {
+			return n, err
+		}
+		wait := p.backoff << (n - 1)
+		log.Warnf("Attempt %d failed: %v, retrying in %v", n, err, wait)
+		time.Sleep(wait)
+
+		// the connection of the failed attempt may be dead but still cached by the round tripper
+		roundTripper := p.newTransport()
+		retryClient := *client
+		retryClient.Transport = roundTripper
+		err = attempt(&retryClient)
+		roundTripper.Close()
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// socksALPN is the ALPN of the SOCKS5 gateway of the server, one SOCKS5 session per bidirectional stream
+const socksALPN = "quicgo-socks5"
+
+// socksTunnel carries the local SOCKS5 connections to the gateway over a single QUIC connection
+type socksTunnel struct {
+	addr    string
+	tlsConf *tls.Config
+	conf    *quic.Config
+
+	mutex sync.Mutex
+	conn  quic.EarlyConnection
+}
+
+// connection returns the QUIC connection to the gateway, dialing it again once closed
+func (t *socksTunnel) connection(ctx context.Context) (quic.EarlyConnection, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.conn != nil && t.conn.Context().Err() == nil {
+		return t.conn, nil
+	}
+	conn, err := dialHappyEyeballs(ctx, t.addr, t.tlsConf, t.conf)
+	if err != nil {
+		return nil, err
+	}
+	t.conn = conn
+	return conn, nil
+}
+
+func (t *socksTunnel) forward(c net.Conn) {
+	defer c.Close()
+	conn, err := t.connection(context.Background())
+	if err != nil {
+		log.Errorf("Unable to connect to the SOCKS gateway %s: %v", t.addr, err)
+		return
+	}
+	str, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		log.Errorf("Unable to open a stream to the SOCKS gateway: %v", err)
+		return
+	}
+	log.Debugf("Forwarding %s on stream %d", c.RemoteAddr(), str.StreamID())
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(c, str)
+		c.(*net.TCPConn).CloseWrite()
+		close(done)
+	}()
+	io.Copy(str, c)
+	str.Close()
+	<-done
+}
+
+// runSOCKSTunnel accepts the SOCKS5 clients on listenAddr and forwards them to the gateway of the server of rawURL.
+// The SOCKS5 negotiation is done by the gateway, the tunnel only relays the bytes.
+func runSOCKSTunnel(listenAddr, rawURL string, tlsConf *tls.Config, conf *quic.Config) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{socksALPN}
+	if tlsConf.ServerName == "" {
+		tlsConf.ServerName = u.Hostname()
+	}
+	t := &socksTunnel{addr: addr, tlsConf: tlsConf, conf: conf}
+	log.Infof("Forwarding the SOCKS5 connections of %s to %s", ln.Addr(), addr)
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go t.forward(c)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// quicTransports are the UDP sockets the QUIC connections of the client are dialed from, one per address
+// family shared by all the connections, where quic.DialAddrEarly would open a socket for each one
+type quicTransports struct {
+	mutex     sync.Mutex
+	byNetwork map[string]*quic.Transport
+}
+
+var transports = &quicTransports{byNetwork: make(map[string]*quic.Transport)}
+
+// get returns the transport of network (udp4 or udp6), opening its socket on first use, bound to the
+// address of -local-addr or -interface if any
+func (t *quicTransports) get(network string) (*quic.Transport, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if tr, ok := t.byNetwork[network]; ok {
+		return tr, nil
+	}
+	laddr, err := source.listenAddr(network)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Sending the %s packets from %s", network, conn.LocalAddr())
+	tr := &quic.Transport{Conn: conn}
+	t.byNetwork[network] = tr
+	return tr, nil
+}
+
+// dial opens a QUIC connection to ip and port from the transport of the family of ip
+func (t *quicTransports) dial(ctx context.Context, ip net.IP, port string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	network := "udp6"
+	if ip.To4() != nil {
+		network = "udp4"
+	}
+	tr, err := t.get(network)
+	if err != nil {
+		return nil, err
+	}
+	return tr.DialEarly(ctx, &net.UDPAddr{IP: ip, Port: p}, tlsCfg, cfg)
+}
+
+// close closes the connections of the transports and their sockets
+func (t *quicTransports) close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for network, tr := range t.byNetwork {
+		tr.Close()
+		tr.Conn.Close()
+		delete(t.byNetwork, network)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// generateKey creates a private key of keyType: rsa, ecdsa (P-256) or ed25519
+func generateKey(keyType string, rsaBits int) (crypto.Signer, error) {
+	switch keyType {
+	case "rsa":
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	case "ecdsa":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unknown key type %s, expected rsa, ecdsa or ed25519", keyType)
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// parseSANs sorts the comma separated subject alternative names into IPs, URIs, emails and DNS names
+func parseSANs(v string, tmpl *x509.Certificate) error {
+	for _, san := range strings.Split(v, ",") {
+		san = strings.TrimSpace(san)
+		switch {
+		case len(san) == 0:
+		case net.ParseIP(san) != nil:
+			tmpl.IPAddresses = append(tmpl.IPAddresses, net.ParseIP(san))
+		case strings.Contains(san, "://"):
+			u, err := url.Parse(san)
+			if err != nil {
+				return fmt.Errorf("invalid URI SAN %s: %w", san, err)
+			}
+			tmpl.URIs = append(tmpl.URIs, u)
+		case strings.Contains(san, "@"):
+			tmpl.EmailAddresses = append(tmpl.EmailAddresses, san)
+		default:
+			tmpl.DNSNames = append(tmpl.DNSNames, san)
+		}
+	}
+	return nil
+}
+
+// writePEM writes a PEM block to path, refusing to replace an existing file unless force is set
+func writePEM(path, blockType string, der []byte, perm fs.FileMode, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s already exists, use -force to replace it", path)
+		}
+		return err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeKeyPair writes the certificate of der and its key in PEM files, the key in PKCS #8
+func writeKeyPair(certPath, keyPath string, der []byte, key crypto.Signer, force bool) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(certPath, "CERTIFICATE", der, 0644, force); err != nil {
+		return err
+	}
+	return writePEM(keyPath, "PRIVATE KEY", keyDER, 0600, force)
+}
+
+// loadCA loads the certificate and the key of an existing CA
+func loadCA(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if !cert.IsCA {
+		return nil, nil, fmt.Errorf("%s is not a CA certificate", certPath)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported key in %s", keyPath)
+	}
+	return cert, key, nil
+}
+
+func main() {
+	verbose := flag.Bool("v", false, "verbose")
+	outDir := flag.String("out", ".", "Directory the PEM files are written to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "Comma separated subject alternative names of the server certificate: DNS names, IPs, emails or URIs")
+	commonName := flag.String("cn", "", "Common name of the server certificate (defaults to its first SAN)")
+	keyType := flag.String("key-type", "ecdsa", "Type of the keys: rsa, ecdsa (P-256) or ed25519")
+	rsaBits := flag.Int("rsa-bits", 2048, "Size of the RSA keys")
+	validity := flag.Duration("validity", 365*24*time.Hour, "Validity of the server and client certificates")
+	caValidity := flag.Duration("ca-validity", 10*365*24*time.Hour, "Validity of the CA certificate")
+	caCertFile := flag.String("ca-cert", "", "Sign with this existing CA certificate instead of creating ca.pem (with -ca-key)")
+	caKeyFile := flag.String("ca-key", "", "Key file of -ca-cert")
+	clientName := flag.String("client", "", "Also create client.pem and client-key.pem, a client certificate of this common name for mTLS")
+	force := flag.Bool("force", false, "Replace the existing files")
+	flag.Parse()
+
+	log.SetOutput(os.Stdout)
+	if *verbose {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+	if *validity <= 0 || *caValidity <= 0 {
+		log.Fatal("-validity and -ca-validity must be positive")
+	}
+	if (len(*caCertFile) > 0) != (len(*caKeyFile) > 0) {
+		log.Fatal("-ca-cert and -ca-key go together")
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	out := func(name string) string { return filepath.Join(*outDir, name) }
+	now := time.Now()
+
+	var caCert *x509.Certificate
+	var caKey crypto.Signer
+	var err error
+	if len(*caCertFile) > 0 {
+		if caCert, caKey, err = loadCA(*caCertFile, *caKeyFile); err != nil {
+			log.Fatalf("Unable to load the CA: %v", err)
+		}
+		log.Infof("Signing with the CA %s", caCert.Subject)
+	} else {
+		if caKey, err = generateKey(*keyType, *rsaBits); err != nil {
+			log.Fatal(err)
+		}
+		serial, err := serialNumber()
+		if err != nil {
+			log.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber:          serial,
+			Subject:               pkix.Name{CommonName: "quicgo test CA", Organization: []string{"quicgo"}},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.Add(*caValidity),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			MaxPathLenZero:        true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, caKey.Public(), caKey)
+		if err != nil {
+			log.Fatalf("Unable to create the CA certificate: %v", err)
+		}
+		if caCert, err = x509.ParseCertificate(der); err != nil {
+			log.Fatal(err)
+		}
+		if err := writeKeyPair(out("ca.pem"), out("ca-key.pem"), der, caKey, *force); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("Created the CA %s in %s, valid until %s", caCert.Subject, out("ca.pem"), caCert.NotAfter.Format(time.DateOnly))
+	}
+
+	// leaf signs a certificate of tmpl with the CA and writes it with its new key
+	leaf := func(tmpl *x509.Certificate, certPath, keyPath string) {
+		key, err := generateKey(*keyType, *rsaBits)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if tmpl.SerialNumber, err = serialNumber(); err != nil {
+			log.Fatal(err)
+		}
+		tmpl.NotBefore = now.Add(-time.Hour)
+		tmpl.NotAfter = now.Add(*validity)
+		if tmpl.NotAfter.After(caCert.NotAfter) {
+			tmpl.NotAfter = caCert.NotAfter
+		}
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		if _, ok := key.(*rsa.PrivateKey); ok {
+			tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+		}
+		tmpl.BasicConstraintsValid = true
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), caKey)
+		if err != nil {
+			log.Fatalf("Unable to create the certificate %s: %v", certPath, err)
+		}
+		if err := writeKeyPair(certPath, keyPath, der, key, *force); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("Created %s and %s for %s, valid until %s", certPath, keyPath, tmpl.Subject.CommonName, tmpl.NotAfter.Format(time.DateOnly))
+	}
+
+	server := &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	if err := parseSANs(*hosts, server); err != nil {
+		log.Fatal(err)
+	}
+	cn := *commonName
+	if len(cn) == 0 {
+		cn = strings.TrimSpace(strings.Split(*hosts, ",")[0])
+	}
+	server.Subject = pkix.Name{CommonName: cn}
+	leaf(server, out("cert.pem"), out("key.pem"))
+	if len(*clientName) > 0 {
+		leaf(&x509.Certificate{
+			Subject:     pkix.Name{CommonName: *clientName},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, out("client.pem"), out("client-key.pem"))
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// activatedSockets returns the UDP sockets and stream listeners passed by systemd
+// socket activation (LISTEN_PID/LISTEN_FDS), in the order of the socket unit.
+// Both are nil when the process was not socket activated.
+func activatedSockets() ([]*net.UDPConn, []net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid LISTEN_FDS: %w", err)
+	}
+	// the sockets must not be inherited by child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	var conns []*net.UDPConn
+	var lns []net.Listener
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		// net.File* duplicate the descriptor with close-on-exec set, the original can be closed
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("listen-fd-%d", fd))
+		if pc, err := net.FilePacketConn(f); err == nil {
+			f.Close()
+			conn, ok := pc.(*net.UDPConn)
+			if !ok {
+				return nil, nil, fmt.Errorf("activated socket %d is not a UDP socket", fd)
+			}
+			conns = append(conns, conn)
+			continue
+		}
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("unsupported activated socket %d: %w", fd, err)
+		}
+		lns = append(lns, ln)
+	}
+	return conns, lns, nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"net/http"
+)
+
+// newAdminServer creates the plain HTTP server of the admin endpoints.
+// It must only listen on a trusted interface, nothing is authenticated.
+func newAdminServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/debug/qlog", onDemandQlogs.handler)
+	// registered by net/http/pprof
+	mux.Handle("/debug/pprof/", http.DefaultServeMux)
+	return &http.Server{Handler: mux}
+}
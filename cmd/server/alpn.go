@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// rawALPNs are the raw QUIC protocols which can share the HTTP/3 socket
+var rawALPNs = map[string]bool{
+	hqALPN:    true,
+	echoALPN:  true,
+	socksALPN: true,
+}
+
+// parseALPNs parses a comma separated list of raw QUIC protocols
+func parseALPNs(v string) ([]string, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	var alpns []string
+	for _, alpn := range strings.Split(v, ",") {
+		alpn = strings.TrimSpace(alpn)
+		if !rawALPNs[alpn] {
+			return nil, fmt.Errorf("unknown ALPN %s", alpn)
+		}
+		alpns = append(alpns, alpn)
+	}
+	return alpns, nil
+}
+
+// withExtraALPNs returns a copy of the tls.Config of http3.ConfigureTLSConfig also offering alpns
+func withExtraALPNs(h3Conf *tls.Config, alpns []string) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			conf, err := h3Conf.GetConfigForClient(hello)
+			if conf != nil {
+				// crypto/tls selects the first of these protocols offered by the client: h3 for the clients offering it next to alpns
+				conf = conf.Clone()
+				conf.NextProtos = append(conf.NextProtos, alpns...)
+			}
+			return conf, err
+		},
+	}
+}
+
+// alpnListener is fed with the connections of one protocol by an alpnRouter
+type alpnListener struct {
+	conns chan quic.EarlyConnection
+	addr  net.Addr
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *alpnListener) Accept(ctx context.Context) (quic.EarlyConnection, error) {
+	select {
+	case conn, ok := <-l.conns:
+		if !ok {
+			return nil, quic.ErrServerClosed
+		}
+		return conn, nil
+	case <-l.done:
+		return nil, quic.ErrServerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *alpnListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *alpnListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// alpnRouter dispatches the connections accepted on a listener by negotiated ALPN
+type alpnRouter struct {
+	ln        http3.QUICEarlyListener
+	listeners map[string]*alpnListener
+}
+
+func newALPNRouter(ln http3.QUICEarlyListener) *alpnRouter {
+	return &alpnRouter{ln: ln, listeners: make(map[string]*alpnListener)}
+}
+
+// listener returns the listener of the connections negotiating one of alpns, to call before serve
+func (r *alpnRouter) listener(alpns ...string) http3.QUICEarlyListener {
+	l := &alpnListener{conns: make(chan quic.EarlyConnection), addr: r.ln.Addr(), done: make(chan struct{})}
+	for _, alpn := range alpns {
+		r.listeners[alpn] = l
+	}
+	return l
+}
+
+// serve accepts the connections until the listener is closed, then closes the protocol listeners
+func (r *alpnRouter) serve() error {
+	defer func() {
+		closed := make(map[*alpnListener]bool)
+		for _, l := range r.listeners {
+			if !closed[l] {
+				closed[l] = true
+				close(l.conns)
+			}
+		}
+	}()
+	for {
+		conn, err := r.ln.Accept(context.Background())
+		if err != nil {
+			return err
+		}
+		alpn := conn.ConnectionState().TLS.NegotiatedProtocol
+		l, ok := r.listeners[alpn]
+		if !ok {
+			log.Debugf("Connection from %s with unexpected ALPN %q", conn.RemoteAddr(), alpn)
+			conn.CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeVersionFallback), "")
+			continue
+		}
+		select {
+		case l.conns <- conn:
+		case <-l.done:
+			conn.CloseWithError(0, "")
+		}
+	}
+}
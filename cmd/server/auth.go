@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// basicAuth protects next with HTTP basic authentication.
+// creds has the form "user:password".
+func basicAuth(creds string, next http.Handler) http.Handler {
+	expectedUser, expectedPassword, _ := strings.Cut(creds, ":")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(expectedUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(expectedPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="quicgo"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
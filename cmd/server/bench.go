@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// benchBufferSize is the size of the buffers used to move benchmark data
+const benchBufferSize = 64 << 10
+
+// goodputMbps computes the goodput in Mbit/s of n bytes transferred during elapsed
+func goodputMbps(n int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(n) * 8 / elapsed.Seconds() / 1e6
+}
+
+type uploadResult struct {
+	ReceivedBytes  int64   `json:"received_bytes"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	GoodputMbps    float64 `json:"goodput_mbps"`
+}
+
+// benchUploadHandler discards the request body and reports how fast it was received
+func benchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	start := time.Now()
+	n, err := io.CopyBuffer(io.Discard, r.Body, make([]byte, benchBufferSize))
+	elapsed := time.Since(start)
+	if err != nil {
+		if !bodyTooLarge(w, err) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploadResult{
+		ReceivedBytes:  n,
+		ElapsedSeconds: elapsed.Seconds(),
+		GoodputMbps:    goodputMbps(n, elapsed),
+	})
+}
+
+// maxBenchDuration bounds the duration a client can request on the timed benchmark endpoints
+const maxBenchDuration = 5 * time.Minute
+
+// benchDownloadHandler streams data for the wall-clock duration given by the duration query parameter.
+// The achieved rate is sent in trailers. Note that the HTTP/3 implementation
+// does not send trailers yet: they are only received over the TCP fallback.
+func benchDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+	if err != nil || duration <= 0 || duration > maxBenchDuration {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Trailer", "X-Bench-Bytes, X-Bench-Elapsed, X-Bench-Goodput-Mbps")
+	w.WriteHeader(http.StatusOK)
+
+	data := prData.bytes(benchBufferSize)
+	var n int64
+	start := time.Now()
+	deadline := start.Add(duration)
+	for time.Now().Before(deadline) {
+		written, err := w.Write(data)
+		n += int64(written)
+		if err != nil {
+			return
+		}
+	}
+	elapsed := time.Since(start)
+
+	w.Header().Set("X-Bench-Bytes", strconv.FormatInt(n, 10))
+	w.Header().Set("X-Bench-Elapsed", elapsed.String())
+	w.Header().Set("X-Bench-Goodput-Mbps", strconv.FormatFloat(goodputMbps(n, elapsed), 'f', 2, 64))
+}
+
+type pingResult struct {
+	Received int64 `json:"server_received_ns"` // unix time in ns
+	Sent     int64 `json:"server_sent_ns"`
+}
+
+// pingHandler answers with the time the request was received and the time the response was sent
+func pingHandler(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(pingResult{
+		Received: received.UnixNano(),
+		Sent:     time.Now().UnixNano(),
+	})
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// bindConfig holds the settings of one listener
+type bindConfig struct {
+	addr       string
+	handler    handlerConfig
+	qlog       bool
+	qlogDir    string
+	qlogEvents map[string]bool // qlog event categories, nil for all
+	tcp        bool
+
+	keyLogPerConn bool // write the TLS secrets of each connection to its own file in qlogDir, next to its qlog
+	h3FrameLog    bool // log the HTTP/3 frames at debug level
+
+	retry     bool
+	allow0RTT bool
+	// path prefixes where the unsafe methods are accepted in 0-RTT, rejected with 425 elsewhere
+	earlyDataRoutes []string
+	noPMTUD         bool // disable the Path MTU Discovery, packets stay at the initial size
+
+	tokenMaxAge      time.Duration  // lifetime of the NEW_TOKEN address validation tokens, 0 for the quic-go default
+	retryTokenMaxAge time.Duration  // lifetime of the Retry tokens, 0 for the quic-go default
+	hq               bool           // serve HTTP/0.9 for the quic-interop-runner instead of HTTP/3
+	alpns            []string       // raw QUIC protocols served next to HTTP/3 on the same socket
+	socksAllow       []netip.Prefix // internal networks the SOCKS5 gateway may connect to
+
+	maxConnsPerIP    int // handshakes allowed per client IP in connsPerIPWindow, 0 for no limit
+	connsPerIPWindow time.Duration
+
+	headerTimeout     time.Duration // time given to the clients to send the headers of a request, 0 for no limit
+	streamIdleTimeout time.Duration // time a request stream may stay without data sent or received, 0 for no limit
+
+	tcpListen     string // address of the TCP fallback listener, defaults to addr
+	httpsRedirect bool   // redirect the plain HTTP requests sent to the TCP fallback listener to HTTPS
+	family        int    // with an interface name as host, bind only its IPv4 (4) or IPv6 (6) addresses, 0 for both
+
+	drainTimeout time.Duration // time left to the connections to close after a binary upgrade, the same for all the binds
+
+	conn  net.PacketConn // UDP socket of the QUIC listener
+	tcpLn net.Listener   // listener of the TCP fallback, nil when disabled
+}
+
+// parseBind splits a -bind entry of the form "host:port?www=/srv/a&qlog=true" into
+// the listen address and its own options. Options not present in the entry keep
+// the value given in defaults.
+func parseBind(spec string, defaults bindConfig) (bindConfig, error) {
+	bc := defaults
+	addr, query, found := strings.Cut(spec, "?")
+	bc.addr = addr
+	if !found {
+		return bc, nil
+	}
+
+	opts, err := url.ParseQuery(query)
+	if err != nil {
+		return bc, fmt.Errorf("invalid options for bind %s: %w", addr, err)
+	}
+	for name := range opts {
+		value := opts.Get(name)
+		switch name {
+		case "www":
+			bc.handler.www = value
+		case "mount":
+			// repeated, replacing the ones of -mount
+			bc.handler.mounts = opts[name]
+			for _, mount := range bc.handler.mounts {
+				if _, _, err := parseMount(mount); err != nil {
+					return bc, err
+				}
+			}
+		case "dav":
+			bc.handler.dav = value
+		case "auth":
+			bc.handler.auth = value
+		case "proxy":
+			if bc.handler.proxy, err = parseProxyOrigin(value); err != nil {
+				return bc, err
+			}
+		case "proxy-cache":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return bc, fmt.Errorf("invalid proxy-cache option for bind %s: %w", addr, err)
+			}
+			bc.handler.proxyCache = size << 20
+		case "proxy-cache-dir":
+			bc.handler.proxyCacheDir = value
+		case "proxy-mirror":
+			if bc.handler.mirror, err = parseProxyOrigin(value); err != nil {
+				return bc, err
+			}
+		case "proxy-mirror-percent":
+			if bc.handler.mirrorPercent, err = strconv.ParseFloat(value, 64); err != nil {
+				return bc, fmt.Errorf("invalid proxy-mirror-percent option for bind %s: %w", addr, err)
+			}
+		case "proxy-client-cert-headers":
+			if bc.handler.proxyClientCert, err = strconv.ParseBool(value); err != nil {
+				return bc, fmt.Errorf("invalid proxy-client-cert-headers option for bind %s: %w", addr, err)
+			}
+		case "max-request-body":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return bc, fmt.Errorf("invalid max-request-body option for bind %s", addr)
+			}
+			bc.handler.maxRequestBody = size << 20
+		case "middlewares":
+			if bc.handler.middlewares, err = parseMiddlewares(value); err != nil {
+				return bc, err
+			}
+		case "qlog":
+			if bc.qlog, err = strconv.ParseBool(value); err != nil {
+				return bc, fmt.Errorf("invalid qlog option for bind %s: %w", addr, err)
+			}
+		case "qlog-dir":
+			bc.qlogDir = value
+		case "keylog-per-conn":
+			if bc.keyLogPerConn, err = strconv.ParseBool(value); err != nil {
+				return bc, fmt.Errorf("invalid keylog-per-conn option for bind %s: %w", addr, err)
+			}
+		case "h3-frame-log":
+			if bc.h3FrameLog, err = strconv.ParseBool(value); err != nil {
+				return bc, fmt.Errorf("invalid h3-frame-log option for bind %s: %w", addr, err)
+			}
+		case "qlog-events":
+			if bc.qlogEvents, err = parseQlogEvents(value); err != nil {
+				return bc, err
+			}
+		case "retry":
+			if bc.retry, err = strconv.ParseBool(value); err != nil {
+				return bc, fmt.Errorf("invalid retry option for bind %s: %w", addr, err)
+			}
+		case "0rtt":
+			if bc.allow0RTT, err = strconv.ParseBool(value); err != nil {
+				return bc, fmt.Errorf("invalid 0rtt option for bind %s: %w", addr, err)
+			}
+		case "0rtt-unsafe-routes":
+			bc.earlyDataRoutes = parseRoutes(value)
+		case "disable-pmtud":
+			if bc.noPMTUD, err = strconv.ParseBool(value); err != nil {
+				return bc, fmt.Errorf("invalid disable-pmtud option for bind %s: %w", addr, err)
+			}
+		case "header-timeout":
+			if bc.headerTimeout, err = time.ParseDuration(value); err != nil || bc.headerTimeout < 0 {
+				return bc, fmt.Errorf("invalid header-timeout option for bind %s", addr)
+			}
+		case "stream-idle-timeout":
+			if bc.streamIdleTimeout, err = time.ParseDuration(value); err != nil || bc.streamIdleTimeout < 0 {
+				return bc, fmt.Errorf("invalid stream-idle-timeout option for bind %s", addr)
+			}
+		case "token-max-age":
+			if bc.tokenMaxAge, err = time.ParseDuration(value); err != nil {
+				return bc, fmt.Errorf("invalid token-max-age option for bind %s: %w", addr, err)
+			}
+		case "retry-token-max-age":
+			if bc.retryTokenMaxAge, err = time.ParseDuration(value); err != nil {
+				return bc, fmt.Errorf("invalid retry-token-max-age option for bind %s: %w", addr, err)
+			}
+		case "max-conns-per-ip":
+			if bc.maxConnsPerIP, err = strconv.Atoi(value); err != nil {
+				return bc, fmt.Errorf("invalid max-conns-per-ip option for bind %s: %w", addr, err)
+			}
+		case "conns-per-ip-window":
+			if bc.connsPerIPWindow, err = time.ParseDuration(value); err != nil || bc.connsPerIPWindow <= 0 {
+				return bc, fmt.Errorf("invalid conns-per-ip-window option for bind %s", addr)
+			}
+		case "alpn":
+			if bc.alpns, err = parseALPNs(value); err != nil {
+				return bc, err
+			}
+		case "tcp":
+			if bc.tcp, err = strconv.ParseBool(value); err != nil {
+				return bc, fmt.Errorf("invalid tcp option for bind %s: %w", addr, err)
+			}
+		case "tcp-listen":
+			bc.tcpListen = value
+		case "https-redirect":
+			if bc.httpsRedirect, err = strconv.ParseBool(value); err != nil {
+				return bc, fmt.Errorf("invalid https-redirect option for bind %s: %w", addr, err)
+			}
+		case "url-rules":
+			if bc.handler.urlRules, err = loadURLRules(value); err != nil {
+				return bc, err
+			}
+		case "family":
+			if bc.family, err = strconv.Atoi(value); err != nil || (bc.family != 4 && bc.family != 6) {
+				return bc, fmt.Errorf("invalid family option for bind %s, expected 4 or 6", addr)
+			}
+		default:
+			return bc, fmt.Errorf("unknown option %s for bind %s", name, addr)
+		}
+	}
+	return bc, nil
+}
+
+// tcpAddr returns the address of the TCP fallback listener
+func (bc *bindConfig) tcpAddr() string {
+	if len(bc.tcpListen) > 0 {
+		return bc.tcpListen
+	}
+	return bc.addr
+}
+
+// open creates the sockets of the listener described by bc, or takes them over from the previous process
+func (bc *bindConfig) open() error {
+	conn, err := inheritedPacketConn("udp " + bc.addr)
+	if err != nil {
+		return err
+	}
+	if conn == nil {
+		if conn, err = net.ListenPacket("udp", bc.addr); err != nil {
+			return err
+		}
+	}
+	bc.conn = routePackets(conn, takeInherited("route udp "+bc.addr))
+	if !bc.tcp {
+		return nil
+	}
+
+	bc.tcpLn, err = inheritedListener("tcp " + bc.tcpAddr())
+	if bc.tcpLn == nil && err == nil {
+		bc.tcpLn, err = listenTCP(bc.tcpAddr())
+	}
+	if err != nil {
+		bc.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// serveBind serves HTTP/3 on the listener described by bc, plus the TCP fallback when enabled.
+// It returns as soon as one of the servers fails, or once ctx is done. Once drain is closed,
+// it stops accepting connections and returns when the open ones are closed.
+func serveBind(ctx context.Context, bc bindConfig, tlsConf *tls.Config, drain <-chan struct{}) error {
+	handler, err := setupHandler(bc.handler)
+	if err != nil {
+		return err
+	}
+	if bc.allow0RTT {
+		handler = rejectUnsafeEarlyData(bc.earlyDataRoutes, handler)
+	}
+	quicConf := &quic.Config{
+		RequireAddressValidation: func(net.Addr) bool { return bc.retry },
+		Allow0RTT:                bc.allow0RTT,
+		DisablePathMTUDiscovery:  bc.noPMTUD,
+		// quic-go accepts Retry tokens for twice the handshake idle timeout
+		HandshakeIdleTimeout: bc.retryTokenMaxAge / 2,
+		// for the HTTP datagrams of /demo/datagram, ServeListener does not set it
+		EnableDatagrams: true,
+	}
+	tracers := []func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer{newHandshakeTracer(), newStatsTracer(), newConnLoggerTracer()}
+	if bc.qlog {
+		tracers = append(tracers, newQlogTracer(bc.qlogDir, bc.qlogEvents))
+	} else if onDemandQlogs.enabled {
+		// the qlog of a live connection can still be captured from the admin server
+		tracers = append(tracers, onDemandQlogs.tracer(bc.qlogDir, bc.qlogEvents))
+		handler = onDemandQlogs.captureOnHeader(handler)
+	}
+	handler = withConnLogger(handler)
+	var keyLogs *connKeyLogs
+	if bc.keyLogPerConn {
+		keyLogs = newConnKeyLogs(bc.qlogDir)
+		tracers = append(tracers, keyLogs.tracer())
+	}
+	if memBudget != nil {
+		tracers = append(tracers, memBudget.tracer)
+	}
+	quicConf.Tracer = newMultiplexedTracer(tracers...)
+	if memBudget != nil {
+		// after the tracer, copied to the config of each connection
+		memBudget.limit(quicConf)
+	}
+	tr := &quic.Transport{
+		Conn:        bc.conn,
+		Tracer:      newHandshakeTransportTracer(),
+		MaxTokenAge: bc.tokenMaxAge,
+		// routes the packets to the process serving their connection on a binary upgrade
+		ConnectionIDGenerator: generationConnIDs{},
+	}
+	defer tr.Close()
+	quicTLSConf := tlsConf
+	if bc.maxConnsPerIP > 0 {
+		quicTLSConf = limitHandshakesPerIP(tlsConf, bc.maxConnsPerIP, bc.connsPerIPWindow)
+	}
+	if keyLogs != nil {
+		quicTLSConf = keyLogs.configure(quicTLSConf)
+	}
+	// closing the listeners leaves the connections open, they are counted to be drained
+	var active atomic.Int64
+	if bc.hq {
+		hqTLSConf := quicTLSConf.Clone()
+		hqTLSConf.NextProtos = []string{hqALPN}
+		ln, err := tr.ListenEarly(hqTLSConf, quicConf)
+		if err != nil {
+			return err
+		}
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-drain:
+			}
+			ln.Close()
+		}()
+		if err := serveHQ(&gsoListener{QUICEarlyListener: &trackingListener{QUICEarlyListener: ln, active: &active}}, handler); err != nil && !errors.Is(err, quic.ErrServerClosed) {
+			return err
+		}
+		select {
+		case <-drain:
+			drainCtx, cancel := context.WithTimeout(ctx, bc.drainTimeout)
+			defer cancel()
+			waitDrained(drainCtx, &active)
+			waitRelayed(drainCtx, bc.conn)
+		default:
+		}
+		return nil
+	}
+	h3TLSConf := http3.ConfigureTLSConfig(quicTLSConf)
+	if len(bc.alpns) > 0 {
+		h3TLSConf = withExtraALPNs(h3TLSConf, bc.alpns)
+	}
+	ln, err := tr.ListenEarly(h3TLSConf, quicConf)
+	if err != nil {
+		return err
+	}
+	quicServer := &http3.Server{
+		Handler:    handler,
+		Addr:       bc.addr,
+		TLSConfig:  tlsConf,
+		QuicConfig: quicConf,
+		// for the extended CONNECT of /demo/capsules
+		AdditionalSettings: map[uint64]uint64{settingExtendedConnect: 1},
+		EnableDatagrams:    true,
+	}
+	var tcpServer *http.Server
+	tcpLn := bc.tcpLn
+	if tcpLn != nil {
+		tcpServer = newTCPServer(quicServer, handler, tlsConf)
+		tcpServer.ReadHeaderTimeout = bc.headerTimeout
+		log.Info("Start TCP fallback listening on " + tcpLn.Addr().String())
+		// the unix sockets serve plain HTTP
+		if bc.httpsRedirect && tcpLn.Addr().Network() != "unix" {
+			tcpLn = newHTTPSRedirectListener(tcpLn)
+		}
+	}
+
+	errs := make(chan error, 3+len(bc.alpns))
+	var h3Ln http3.QUICEarlyListener = &gsoListener{QUICEarlyListener: &trackingListener{QUICEarlyListener: ln, active: &active}}
+	if len(bc.alpns) > 0 {
+		router := newALPNRouter(h3Ln)
+		h3Ln = router.listener(http3.NextProtoH3)
+		for _, alpn := range bc.alpns {
+			rawLn := router.listener(alpn)
+			switch alpn {
+			case hqALPN:
+				go func() { errs <- serveHQ(rawLn, handler) }()
+			case echoALPN:
+				go func() { errs <- serveEcho(rawLn) }()
+			case socksALPN:
+				go func() { errs <- serveSOCKS(rawLn, bc.handler.auth, bc.socksAllow) }()
+			}
+		}
+		go func() { errs <- router.serve() }()
+	}
+	if bc.headerTimeout > 0 || bc.streamIdleTimeout > 0 {
+		h3Ln = &streamTimeoutListener{QUICEarlyListener: h3Ln, headerTimeout: bc.headerTimeout, idleTimeout: bc.streamIdleTimeout}
+	}
+	if bc.h3FrameLog {
+		h3Ln = &h3FrameLogListener{QUICEarlyListener: h3Ln}
+	}
+	go func() {
+		errs <- quicServer.ServeListener(h3Ln)
+	}()
+	if tcpServer != nil {
+		go func() {
+			errs <- serveTCP(tcpServer, tcpLn)
+		}()
+	}
+
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	case <-drain:
+		log.Infof("Draining the connections of %s", bc.addr)
+		ln.Close()
+		drainCtx, cancel := context.WithTimeout(ctx, bc.drainTimeout)
+		defer cancel()
+		if tcpServer != nil {
+			go tcpServer.Shutdown(drainCtx)
+		}
+		waitDrained(drainCtx, &active)
+		waitRelayed(drainCtx, bc.conn)
+	}
+	quicServer.Close()
+	bc.conn.Close()
+	if tcpServer != nil {
+		tcpServer.Close()
+	}
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, quic.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
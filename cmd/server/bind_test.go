@@ -0,0 +1,95 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSplitBinds(t *testing.T) {
+	tests := []struct {
+		value   string
+		entries []string
+	}{
+		{"a:1", []string{"a:1"}},
+		{"a:1,b:2", []string{"a:1", "b:2"}},
+		{"a:1?alpn=hq-interop,quicgo-echo", []string{"a:1?alpn=hq-interop,quicgo-echo"}},
+		{"a:1?alpn=hq-interop,quicgo-echo,b:2", []string{"a:1?alpn=hq-interop,quicgo-echo", "b:2"}},
+		{"a:1?middlewares=log,gzip&qlog=true,[::1]:2?qlog-events=transport,security", []string{"a:1?middlewares=log,gzip&qlog=true", "[::1]:2?qlog-events=transport,security"}},
+		{"a:1?0rtt-unsafe-routes=/upload,/api,eth0:2?family=4", []string{"a:1?0rtt-unsafe-routes=/upload,/api", "eth0:2?family=4"}},
+	}
+	for _, test := range tests {
+		if entries := splitBinds(test.value); !slices.Equal(entries, test.entries) {
+			t.Errorf("splitBinds(%q) = %q, expected %q", test.value, entries, test.entries)
+		}
+	}
+}
+
+func TestBindsSet(t *testing.T) {
+	var bs binds
+	bs.Set("a:1,b:2?alpn=hq-interop,quicgo-echo")
+	bs.Set("c:3")
+	if expected := (binds{"a:1", "b:2?alpn=hq-interop,quicgo-echo", "c:3"}); !slices.Equal(bs, expected) {
+		t.Errorf("binds %q, expected %q", bs, expected)
+	}
+}
+
+func TestParseBind(t *testing.T) {
+	dir := t.TempDir()
+	defaults := bindConfig{qlogDir: "/var/qlog", headerTimeout: 10 * time.Second, handler: handlerConfig{www: "/srv/www"}}
+	tests := []struct {
+		spec    string
+		check   func(bindConfig) bool
+		invalid bool
+	}{
+		{spec: "a:1", check: func(bc bindConfig) bool {
+			return bc.addr == "a:1" && bc.handler.www == "/srv/www" && bc.qlogDir == "/var/qlog" && !bc.qlog
+		}},
+		{spec: "a:1?www=/srv/a&qlog=true", check: func(bc bindConfig) bool {
+			return bc.addr == "a:1" && bc.handler.www == "/srv/a" && bc.qlog && bc.qlogDir == "/var/qlog"
+		}},
+		{spec: "[::1]:443?header-timeout=1s&family=6", check: func(bc bindConfig) bool {
+			return bc.addr == "[::1]:443" && bc.headerTimeout == time.Second && bc.family == 6
+		}},
+		{spec: "a:1?alpn=hq-interop,quicgo-echo", check: func(bc bindConfig) bool {
+			return slices.Equal(bc.alpns, []string{"hq-interop", "quicgo-echo"})
+		}},
+		{spec: "a:1?middlewares=log,gzip", check: func(bc bindConfig) bool {
+			return slices.Equal(bc.handler.middlewares, []string{"log", "gzip"})
+		}},
+		{spec: "a:1?0rtt-unsafe-routes=/upload,/api", check: func(bc bindConfig) bool {
+			return slices.Equal(bc.earlyDataRoutes, []string{"/upload", "/api"})
+		}},
+		{spec: "a:1?qlog-events=transport,security", check: func(bc bindConfig) bool {
+			return len(bc.qlogEvents) == 2 && bc.qlogEvents["transport"] && bc.qlogEvents["security"]
+		}},
+		{spec: "a:1?mount=/a%3D" + dir + "&mount=/b%3D" + dir, check: func(bc bindConfig) bool {
+			return slices.Equal(bc.handler.mounts, []string{"/a=" + dir, "/b=" + dir})
+		}},
+		{spec: "a:1?mount=/a%3D" + dir + "/missing", invalid: true},
+		{spec: "a:1?proxy-cache=2", check: func(bc bindConfig) bool {
+			return bc.handler.proxyCache == 2<<20
+		}},
+		{spec: "a:1?unknown=1", invalid: true},
+		{spec: "a:1?qlog=maybe", invalid: true},
+		{spec: "a:1?alpn=h2", invalid: true},
+		{spec: "a:1?family=5", invalid: true},
+		{spec: "a:1?header-timeout=-1s", invalid: true},
+		{spec: "a:1?max-request-body=-1", invalid: true},
+		{spec: "a:1?www=%zz", invalid: true},
+	}
+	for _, test := range tests {
+		bc, err := parseBind(test.spec, defaults)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("parseBind(%q) accepted", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBind(%q): %v", test.spec, err)
+		} else if !test.check(bc) {
+			t.Errorf("parseBind(%q) = %+v", test.spec, bc)
+		}
+	}
+}
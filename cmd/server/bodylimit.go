@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// limitBody caps the request bodies of next at max bytes, 0 for no limit. The requests announcing a
+// larger body are refused with 413 right away, next answers 413 as well when the limit is reached while
+// it reads the body, see bodyTooLarge.
+func limitBody(max int64, next http.HandlerFunc) http.HandlerFunc {
+	if max <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			http.Error(w, fmt.Sprintf("request body larger than %d bytes", max), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next(w, r)
+	}
+}
+
+// bodyTooLarge answers 413 when err is the one of the limit of limitBody, telling whether it did
+func bodyTooLarge(w http.ResponseWriter, err error) bool {
+	var maxErr *http.MaxBytesError
+	if !errors.As(err, &maxErr) {
+		return false
+	}
+	http.Error(w, fmt.Sprintf("request body larger than %d bytes", maxErr.Limit), http.StatusRequestEntityTooLarge)
+	return true
+}
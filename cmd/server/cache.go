@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cacheMetaSuffix = ".meta" // of the files of a disk cache holding the status and header of a response
+	cacheTempSuffix = ".tmp"  // of the files of a disk cache receiving the body of a response
+)
+
+// cacheEntry is one stored variant of a cached response
+type cacheEntry struct {
+	key     string
+	vary    map[string]string // request header values selected by the Vary response header
+	status  int
+	header  http.Header
+	body    []byte // nil in a disk cache
+	file    string // path of the body in a disk cache, next to its .meta file
+	size    int64
+	stored  time.Time
+	expires time.Time
+	elem    *list.Element
+}
+
+// cacheMeta is the content of the .meta file of a response stored in a disk cache
+type cacheMeta struct {
+	Key     string
+	Vary    map[string]string
+	Status  int
+	Header  http.Header
+	Stored  time.Time
+	Expires time.Time
+}
+
+func removeCacheFiles(file string) {
+	os.Remove(file)
+	os.Remove(file + cacheMetaSuffix)
+}
+
+func (e *cacheEntry) matches(r *http.Request) bool {
+	for name, value := range e.vary {
+		if r.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// responseCache is an LRU cache of proxied responses honoring Cache-Control and Vary,
+// kept in memory or in the files of a directory
+type responseCache struct {
+	dir      string // directory of a disk cache, empty in memory
+	mutex    sync.Mutex
+	maxBytes int64
+	size     int64
+	lru      *list.List // most recently used entries first
+	entries  map[string][]*cacheEntry
+}
+
+func newResponseCache(maxBytes int64) *responseCache {
+	return &responseCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string][]*cacheEntry),
+	}
+}
+
+// newDiskResponseCache returns a cache keeping up to maxBytes of responses in the files of dir,
+// with the responses stored there by the previous runs which are still fresh
+func newDiskResponseCache(dir string, maxBytes int64) (*responseCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	c := newResponseCache(maxBytes)
+	c.dir = dir
+	now := time.Now()
+	var loaded []*cacheEntry
+	for _, f := range files {
+		name, isMeta := strings.CutSuffix(f.Name(), cacheMetaSuffix)
+		path := filepath.Join(dir, name)
+		if !isMeta {
+			// bodies without their .meta file, and left by an interrupted transfer
+			if _, err := os.Stat(path + cacheMetaSuffix); err != nil {
+				os.Remove(path)
+			}
+			continue
+		}
+		e, err := loadCacheEntry(path)
+		if err != nil || now.After(e.expires) {
+			removeCacheFiles(path)
+			continue
+		}
+		loaded = append(loaded, e)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].stored.Before(loaded[j].stored) })
+	for _, e := range loaded {
+		c.addLocked(e)
+	}
+	log.Infof("Loaded %d fresh responses from the proxy cache in %s", c.lru.Len(), dir)
+	return c, nil
+}
+
+// loadCacheEntry reads the response of a disk cache whose body is in file
+func loadCacheEntry(file string) (*cacheEntry, error) {
+	raw, err := os.ReadFile(file + cacheMetaSuffix)
+	if err != nil {
+		return nil, err
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheEntry{
+		key:     meta.Key,
+		vary:    meta.Vary,
+		status:  meta.Status,
+		header:  meta.Header,
+		file:    file,
+		size:    info.Size(),
+		stored:  meta.Stored,
+		expires: meta.Expires,
+	}, nil
+}
+
+func cacheKey(r *http.Request) string {
+	return r.Host + r.URL.RequestURI()
+}
+
+func parseCacheControl(v string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(v, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+	return directives
+}
+
+// freshness returns how long a response with the given header may be served from the cache, false
+// when it must not be stored. The responses setting a cookie are only stored when explicitly public,
+// the cache being shared by all the clients.
+func freshness(header http.Header, now time.Time) (time.Duration, bool) {
+	for _, v := range header.Values("Vary") {
+		if strings.TrimSpace(v) == "*" {
+			return 0, false
+		}
+	}
+
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[directive]; ok {
+			return 0, false
+		}
+	}
+	if _, public := cc["public"]; !public && len(header.Values("Set-Cookie")) > 0 {
+		return 0, false
+	}
+
+	var ttl time.Duration
+	if v, ok := cc["s-maxage"]; ok {
+		secs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		ttl = time.Duration(secs) * time.Second
+	} else if v, ok := cc["max-age"]; ok {
+		secs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		ttl = time.Duration(secs) * time.Second
+	} else if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0, false
+		}
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		ttl = expires.Sub(date)
+	}
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil {
+		ttl -= time.Duration(age) * time.Second
+	}
+	return ttl, ttl > 0
+}
+
+func (c *responseCache) removeLocked(e *cacheEntry) {
+	c.lru.Remove(e.elem)
+	c.size -= e.size
+	if len(e.file) > 0 {
+		removeCacheFiles(e.file)
+	}
+
+	variants := c.entries[e.key]
+	for i, v := range variants {
+		if v == e {
+			variants = append(variants[:i], variants[i+1:]...)
+			break
+		}
+	}
+	if len(variants) == 0 {
+		delete(c.entries, e.key)
+	} else {
+		c.entries[e.key] = variants
+	}
+}
+
+func (c *responseCache) get(r *http.Request, now time.Time) *cacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, e := range c.entries[cacheKey(r)] {
+		if !e.matches(r) {
+			continue
+		}
+		if now.After(e.expires) {
+			c.removeLocked(e)
+			return nil
+		}
+		c.lru.MoveToFront(e.elem)
+		return e
+	}
+	return nil
+}
+
+// addLocked adds e as the most recently used entry, evicting the least recently used ones past maxBytes
+func (c *responseCache) addLocked(e *cacheEntry) {
+	e.elem = c.lru.PushFront(e)
+	c.entries[e.key] = append(c.entries[e.key], e)
+	c.size += e.size
+	for c.size > c.maxBytes {
+		c.removeLocked(c.lru.Back().Value.(*cacheEntry))
+	}
+}
+
+func (c *responseCache) put(r *http.Request, e *cacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, old := range c.entries[e.key] {
+		if old.matches(r) {
+			c.removeLocked(old)
+			break
+		}
+	}
+	c.addLocked(e)
+}
+
+func (c *responseCache) remove(e *cacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, v := range c.entries[e.key] {
+		if v == e {
+			c.removeLocked(e)
+			return
+		}
+	}
+}
+
+// store adds the response recorded by rec to the cache, if it may be stored
+func (c *responseCache) store(r *http.Request, rec *cacheRecorder, now time.Time) {
+	defer func() {
+		// still set unless renamed into the cache
+		if rec.file != nil {
+			rec.file.Close()
+			os.Remove(rec.file.Name())
+		}
+	}()
+	if r.Method != http.MethodGet || rec.dropped {
+		return
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.status != http.StatusOK {
+		return
+	}
+	header := rec.Header().Clone()
+	header.Del("X-Cache")
+	ttl, ok := freshness(header, now)
+	if !ok {
+		return
+	}
+
+	e := &cacheEntry{
+		key:     cacheKey(r),
+		vary:    make(map[string]string),
+		status:  rec.status,
+		header:  header,
+		size:    rec.size,
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			e.vary[name] = r.Header.Get(name)
+		}
+	}
+	if rec.file == nil {
+		e.body = rec.body.Bytes()
+	} else {
+		if err := c.writeFiles(e, rec.file); err != nil {
+			log.Warnf("Unable to store %s in the proxy cache: %v", e.key, err)
+			return
+		}
+		rec.file = nil
+	}
+	c.put(r, e)
+}
+
+// writeFiles writes the .meta file of e, and moves its body from the temporary file body into the disk cache
+func (c *responseCache) writeFiles(e *cacheEntry, body *os.File) error {
+	if err := body.Close(); err != nil {
+		return err
+	}
+	var name [16]byte
+	if _, err := rand.Read(name[:]); err != nil {
+		return err
+	}
+	e.file = filepath.Join(c.dir, hex.EncodeToString(name[:]))
+	meta, err := json.Marshal(cacheMeta{Key: e.key, Vary: e.vary, Status: e.status, Header: e.header, Stored: e.stored, Expires: e.expires})
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(body.Name(), e.file); err != nil {
+		return err
+	}
+	if err := os.WriteFile(e.file+cacheMetaSuffix, meta, 0o600); err != nil {
+		os.Remove(e.file)
+		return err
+	}
+	return nil
+}
+
+// serve sends the response of e, false when its body is no longer on the disk
+func (c *responseCache) serve(w http.ResponseWriter, r *http.Request, e *cacheEntry, now time.Time) bool {
+	var body io.Reader = bytes.NewReader(e.body)
+	if len(e.file) > 0 {
+		f, err := os.Open(e.file)
+		if err != nil {
+			return false
+		}
+		defer f.Close()
+		body = f
+	}
+	for name, values := range e.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Age", strconv.Itoa(int(now.Sub(e.stored).Seconds())))
+	w.Header().Set("X-Cache", "HIT")
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		io.Copy(w, body)
+	}
+	return true
+}
+
+// handler serves cacheable GET/HEAD requests from the cache, and stores the responses of next on a miss.
+// The requests with credentials, a Cookie or Authorization header, bypass the cache (RFC 9111 section 3.5),
+// their responses being specific to the client.
+func (c *responseCache) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqCC := parseCacheControl(r.Header.Get("Cache-Control"))
+		_, noStore := reqCC["no-store"]
+		_, noCache := reqCC["no-cache"]
+		cacheable := (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+			r.Header.Get("Authorization") == "" && r.Header.Get("Cookie") == "" && r.Header.Get("Range") == ""
+		if !cacheable || noStore {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		if !noCache {
+			if e := c.get(r, now); e != nil {
+				if c.serve(w, r, e, now) {
+					return
+				}
+				c.remove(e)
+			}
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		rec := &cacheRecorder{ResponseWriter: w, limit: c.maxBytes}
+		if len(c.dir) > 0 && r.Method == http.MethodGet {
+			var err error
+			if rec.file, err = os.CreateTemp(c.dir, "*"+cacheTempSuffix); err != nil {
+				log.Warnf("Unable to store %s in the proxy cache: %v", cacheKey(r), err)
+				rec.dropped = true
+			}
+		}
+		next.ServeHTTP(rec, r)
+		c.store(r, rec, now)
+	})
+}
+
+// cacheRecorder forwards the response to the client while keeping a copy of it, up to limit bytes,
+// in body or in the temporary file of a disk cache
+type cacheRecorder struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	file    *os.File
+	size    int64
+	limit   int64
+	dropped bool // the copy is not kept, the response being too large or the file not written
+}
+
+func (r *cacheRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *cacheRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.dropped {
+		r.size += int64(len(p))
+		if r.size > r.limit {
+			r.dropped = true
+			r.body = bytes.Buffer{}
+		} else if r.file != nil {
+			if _, err := r.file.Write(p); err != nil {
+				log.Warnf("Unable to write to the proxy cache: %v", err)
+				r.dropped = true
+			}
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *cacheRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *cacheRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
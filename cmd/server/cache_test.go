@@ -0,0 +1,219 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		ttl    time.Duration
+		stored bool
+	}{
+		{"max-age", http.Header{"Cache-Control": {"max-age=60"}}, time.Minute, true},
+		{"s-maxage first", http.Header{"Cache-Control": {"max-age=60, s-maxage=120"}}, 2 * time.Minute, true},
+		{"age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}}, 40 * time.Second, true},
+		{"stale", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"60"}}, 0, false},
+		{"expires", http.Header{"Expires": {now.Add(time.Hour).Format(http.TimeFormat)}, "Date": {now.Format(http.TimeFormat)}}, time.Hour, true},
+		{"invalid expires", http.Header{"Expires": {"0"}}, 0, false},
+		{"invalid max-age", http.Header{"Cache-Control": {"max-age=soon"}}, 0, false},
+		{"no freshness", http.Header{}, 0, false},
+		{"no-store", http.Header{"Cache-Control": {"no-store, max-age=60"}}, 0, false},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache, max-age=60"}}, 0, false},
+		{"private", http.Header{"Cache-Control": {"private, max-age=60"}}, 0, false},
+		{"vary star", http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, 0, false},
+		{"set-cookie", http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"session=1"}}, 0, false},
+		{"public set-cookie", http.Header{"Cache-Control": {"public, max-age=60"}, "Set-Cookie": {"theme=dark"}}, time.Minute, true},
+	}
+	for _, test := range tests {
+		ttl, stored := freshness(test.header, now)
+		if stored != test.stored || (stored && ttl != test.ttl) {
+			t.Errorf("%s: freshness = %v, %v, expected %v, %v", test.name, ttl, stored, test.ttl, test.stored)
+		}
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   http.Header // of the responses of the origin
+		requests []http.Header
+		hits     []bool
+	}{
+		{
+			name:     "cached",
+			header:   http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []http.Header{{}, {}},
+			hits:     []bool{false, true},
+		},
+		{
+			name:     "not storable",
+			header:   http.Header{"Cache-Control": {"no-store"}},
+			requests: []http.Header{{}, {}},
+			hits:     []bool{false, false},
+		},
+		{
+			name:     "set-cookie not stored",
+			header:   http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"session=1"}},
+			requests: []http.Header{{}, {}},
+			hits:     []bool{false, false},
+		},
+		{
+			name:     "public set-cookie stored",
+			header:   http.Header{"Cache-Control": {"public, max-age=60"}, "Set-Cookie": {"theme=dark"}},
+			requests: []http.Header{{}, {}},
+			hits:     []bool{false, true},
+		},
+		{
+			name:     "cookie bypasses",
+			header:   http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []http.Header{{"Cookie": {"session=1"}}, {}, {"Cookie": {"session=1"}}, {}},
+			hits:     []bool{false, false, false, true},
+		},
+		{
+			name:     "authorization bypasses",
+			header:   http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []http.Header{{}, {"Authorization": {"Basic dTpw"}}},
+			hits:     []bool{false, false},
+		},
+		{
+			name:     "range bypasses",
+			header:   http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []http.Header{{}, {"Range": {"bytes=0-1"}}},
+			hits:     []bool{false, false},
+		},
+		{
+			name:     "request no-cache",
+			header:   http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []http.Header{{}, {"Cache-Control": {"no-cache"}}, {}},
+			hits:     []bool{false, false, true},
+		},
+		{
+			name:     "vary",
+			header:   http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Encoding"}},
+			requests: []http.Header{{"Accept-Encoding": {"gzip"}}, {}, {"Accept-Encoding": {"gzip"}}, {}, {"Accept-Encoding": {"br"}}},
+			hits:     []bool{false, false, true, true, false},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, values := range test.header {
+					w.Header()[name] = values
+				}
+				w.Write([]byte("body"))
+			})
+			cache := newResponseCache(1 << 20).handler(origin)
+			for i, header := range test.requests {
+				r := httptest.NewRequest(http.MethodGet, "https://example.com/a", nil)
+				r.Header = header
+				w := httptest.NewRecorder()
+				cache.ServeHTTP(w, r)
+				if hit := w.Header().Get("X-Cache") == "HIT"; hit != test.hits[i] {
+					t.Errorf("request %d: hit %v, expected %v", i, hit, test.hits[i])
+				}
+				if w.Body.String() != "body" {
+					t.Errorf("request %d: body %q", i, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write(make([]byte, 400))
+	})
+	dir := t.TempDir()
+	disk, err := newDiskResponseCache(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*responseCache{newResponseCache(1000), disk} {
+		cache := c.handler(origin)
+		get := func(path string) bool {
+			w := httptest.NewRecorder()
+			cache.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil))
+			return w.Header().Get("X-Cache") == "HIT"
+		}
+		get("/a")
+		get("/b")
+		get("/a") // /b least recently used
+		get("/c")
+		if !get("/a") || !get("/c") {
+			t.Error("recently used entries evicted")
+		}
+		if get("/b") {
+			t.Error("least recently used entry kept")
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 4 {
+		t.Errorf("files %q, expected the ones of the 2 responses kept", files)
+	}
+}
+
+func TestDiskResponseCache(t *testing.T) {
+	dir := t.TempDir()
+	requests := 0
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		maxAge := "60"
+		if r.URL.Path == "/short" {
+			maxAge = "1"
+		}
+		w.Header().Set("Cache-Control", "max-age="+maxAge)
+		w.Write([]byte("body of " + r.URL.Path))
+	})
+	cache, err := newDiskResponseCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(cache http.Handler, path string) bool {
+		w := httptest.NewRecorder()
+		cache.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil))
+		if w.Body.String() != "body of "+path {
+			t.Errorf("%s: body %q", path, w.Body.String())
+		}
+		return w.Header().Get("X-Cache") == "HIT"
+	}
+	if get(cache.handler(origin), "/a") || get(cache.handler(origin), "/short") || !get(cache.handler(origin), "/a") {
+		t.Fatal("response not cached")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 4 {
+		t.Fatalf("files %q, expected a body and a .meta file by response", files)
+	}
+	os.WriteFile(filepath.Join(dir, "1234"+cacheTempSuffix), []byte("interrupted"), 0o600)
+
+	// restart
+	time.Sleep(time.Second)
+	cache, err = newDiskResponseCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.lru.Len() != 1 {
+		t.Errorf("%d responses loaded, expected the fresh one", cache.lru.Len())
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 2 {
+		t.Errorf("files %q left, expected the ones of the fresh response", files)
+	}
+	requests = 0
+	if !get(cache.handler(origin), "/a") || requests != 0 {
+		t.Error("response of the previous run not served")
+	}
+
+	// body removed behind the back of the cache
+	for _, e := range cache.entries["example.com/a"] {
+		os.Remove(e.file)
+	}
+	if get(cache.handler(origin), "/a") || requests != 1 || !get(cache.handler(origin), "/a") {
+		t.Error("response without body not fetched again")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+const (
+	// capsuleEchoProtocol is the :protocol of the extended CONNECT requests of /demo/capsules
+	capsuleEchoProtocol = "capsule-echo"
+	// settingExtendedConnect is SETTINGS_ENABLE_CONNECT_PROTOCOL (RFC 9220)
+	settingExtendedConnect = 0x8
+	// capsuleDatagram is the DATAGRAM capsule type (RFC 9297)
+	capsuleDatagram http3.CapsuleType = 0x0
+	maxCapsuleSize                    = 64 << 10
+)
+
+// capsuleHandler serves the extended CONNECT requests of the capsule-echo protocol: once the request
+// stream is upgraded, it carries capsules (RFC 9297) in both directions, the DATAGRAM ones are sent back
+// and the others dropped as the RFC requires for the unknown types
+func capsuleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect || r.Proto != capsuleEchoProtocol {
+		http.Error(w, "extended CONNECT over HTTP/3 with the :protocol "+capsuleEchoProtocol+" required", http.StatusBadRequest)
+		return
+	}
+	l := loggerFrom(r.Context())
+	w.Header().Set("Capsule-Protocol", "?1")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	in := bufio.NewReader(r.Body)
+	out := bufio.NewWriter(w)
+	var echoed, dropped int
+	for {
+		// the value reader of http3.ParseCapsule fails on the short reads, the header is parsed here
+		ct, err := quicvarint.Read(in)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				l.Debugf("Reading the capsules of %s failed: %v", r.RemoteAddr, err)
+			}
+			break
+		}
+		length, err := quicvarint.Read(in)
+		if err != nil {
+			l.Debugf("Reading the capsules of %s failed: %v", r.RemoteAddr, err)
+			break
+		}
+		if http3.CapsuleType(ct) != capsuleDatagram {
+			if _, err := io.CopyN(io.Discard, in, int64(length)); err != nil {
+				break
+			}
+			dropped++
+			continue
+		}
+		if length > maxCapsuleSize {
+			l.Debugf("DATAGRAM capsule of %s larger than %d bytes, closing the session", r.RemoteAddr, maxCapsuleSize)
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(in, payload); err != nil {
+			l.Debugf("Reading the capsules of %s failed: %v", r.RemoteAddr, err)
+			break
+		}
+		if err := http3.WriteCapsule(out, capsuleDatagram, payload); err != nil {
+			break
+		}
+		if err := out.Flush(); err != nil {
+			break
+		}
+		w.(http.Flusher).Flush()
+		echoed++
+	}
+	l.Debugf("Capsule session of %s closed: %d DATAGRAM capsules echoed, %d capsules of unknown types dropped", r.RemoteAddr, echoed, dropped)
+}
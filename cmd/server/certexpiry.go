@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+)
+
+var certExpiry = newGaugeVec("quicgo_cert_expiry_days", "Days until the loaded certificates expire, negative once expired", "cert")
+
+// watchedCert is a loaded certificate whose expiry is monitored
+type watchedCert struct {
+	name string // role and subject, e.g. "server CN=example.com"
+	cert *x509.Certificate
+}
+
+// checkCertExpiry updates the expiry gauge of certs and warns about the ones expiring within warnBefore.
+// It tells if one of them is already expired.
+func checkCertExpiry(certs []watchedCert, warnBefore time.Duration) bool {
+	expired := false
+	now := time.Now()
+	for _, c := range certs {
+		left := c.cert.NotAfter.Sub(now)
+		certExpiry.set(c.name, left.Hours()/24)
+		switch {
+		case left <= 0:
+			expired = true
+			log.Errorf("Certificate %s expired on %v", c.name, c.cert.NotAfter)
+		case left < warnBefore:
+			log.Warnf("Certificate %s expires in %d days, on %v", c.name, int(left.Hours()/24), c.cert.NotAfter)
+		}
+	}
+	return expired
+}
+
+// watchCertExpiry checks certs every interval until ctx is done
+func watchCertExpiry(ctx context.Context, certs []watchedCert, interval, warnBefore time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCertExpiry(certs, warnBefore)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// maxChatMessage is the size of the longest chat message
+const maxChatMessage = 1 << 10
+
+// chatRoom relays the messages of every member to all of them
+type chatRoom struct {
+	mutex   sync.Mutex
+	members map[chan string]struct{}
+}
+
+func newChatRoom() *chatRoom {
+	return &chatRoom{members: make(map[chan string]struct{})}
+}
+
+func (c *chatRoom) join() chan string {
+	ch := make(chan string, 16)
+	c.mutex.Lock()
+	c.members[ch] = struct{}{}
+	c.mutex.Unlock()
+	return ch
+}
+
+func (c *chatRoom) leave(ch chan string) {
+	c.mutex.Lock()
+	delete(c.members, ch)
+	c.mutex.Unlock()
+}
+
+// broadcast sends msg to the members, the ones too slow to keep up miss it
+func (c *chatRoom) broadcast(msg string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for ch := range c.members {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// eventsHandler streams the messages with server-sent events, for the HTTP/3 and HTTP/2 clients
+func (c *chatRoom) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := c.join()
+	defer c.leave(ch)
+	for {
+		select {
+		case msg := <-ch:
+			for _, line := range strings.Split(msg, "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			io.WriteString(w, "\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// sendHandler broadcasts the body of a POST request
+func (c *chatRoom) sendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	msg, err := io.ReadAll(io.LimitReader(r.Body, maxChatMessage))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.broadcast(string(msg))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// websocketHandler relays the messages over a WebSocket, for the clients of the TCP listener
+func (c *chatRoom) websocketHandler() http.Handler {
+	return websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		ws.MaxPayloadBytes = maxChatMessage
+		ch := c.join()
+		defer c.leave(ch)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				var msg string
+				if err := websocket.Message.Receive(ws, &msg); err != nil {
+					log.Debugf("Chat WebSocket of %s closed: %v", ws.Request().RemoteAddr, err)
+					return
+				}
+				c.broadcast(msg)
+			}
+		}()
+		for {
+			select {
+			case msg := <-ch:
+				if err := websocket.Message.Send(ws, msg); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	})
+}
+
+// demoTransportScript tells the demo pages whether to use WebSockets, for the browsers which did not load them over HTTP/3
+const demoTransportScript = `
+const nav = performance.getEntriesByType("navigation")[0];
+const useWebSocket = !nav || nav.nextHopProtocol !== "h3";
+const wsURL = path => (location.protocol === "https:" ? "wss://" : "ws://") + location.host + path;
+document.getElementById("transport").textContent = useWebSocket ? "WebSocket" : "HTTP/3 (fetch and server-sent events)";
+`
+
+const chatPage = `<!DOCTYPE html>
+<html><head><title>quicgo chat</title></head>
+<body>
+<h1>Chat</h1>
+<p>Transport: <span id="transport"></span></p>
+<pre id="log"></pre>
+<form id="form"><input id="msg" size="60" autocomplete="off"> <button>Send</button></form>
+<script>` + demoTransportScript + `
+const log = document.getElementById("log");
+const show = msg => { log.textContent += msg + "\n"; };
+let send;
+if (useWebSocket) {
+	const ws = new WebSocket(wsURL("/ws/chat"));
+	ws.onmessage = e => show(e.data);
+	send = msg => ws.send(msg);
+} else {
+	new EventSource("/demo/chat/events").onmessage = e => show(e.data);
+	send = msg => fetch("/demo/chat/send", {method: "POST", body: msg});
+}
+document.getElementById("form").onsubmit = e => {
+	e.preventDefault();
+	const input = document.getElementById("msg");
+	if (input.value) send(input.value);
+	input.value = "";
+};
+</script>
+</body></html>
+`
+
+func chatPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, chatPage)
+}
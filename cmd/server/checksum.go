@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"time"
+)
+
+type checksumResult struct {
+	ReceivedBytes  int64   `json:"received_bytes"`
+	SHA256         string  `json:"sha256"`
+	CRC32          string  `json:"crc32"` // IEEE polynomial, the one of gzip and zip
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// checksumHandler computes the digests of the request body while it is received, without storing it
+func checksumHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	start := time.Now()
+	sha := sha256.New()
+	crc := crc32.NewIEEE()
+	n, err := io.CopyBuffer(io.MultiWriter(sha, crc), r.Body, make([]byte, benchBufferSize))
+	if err != nil {
+		if !bodyTooLarge(w, err) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checksumResult{
+		ReceivedBytes:  n,
+		SHA256:         hex.EncodeToString(sha.Sum(nil)),
+		CRC32:          fmt.Sprintf("%08x", crc.Sum32()),
+		ElapsedSeconds: time.Since(start).Seconds(),
+	})
+}
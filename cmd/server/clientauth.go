@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+var revocationFailures = newCounterVec("quicgo_client_cert_revocation_failures_total",
+	"Client certificates found revoked, or whose revocation status could not be checked, by reason", "reason")
+
+const (
+	ocspTimeout = 5 * time.Second
+	// ocspDefaultTTL is how long a response without a next update time is cached
+	ocspDefaultTTL = time.Hour
+)
+
+// loadCertificates reads all the certificates of a PEM file
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(raw); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in %s: %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return certs, nil
+}
+
+// loadCRLs reads the CRLs of a PEM or DER file and checks they are signed by one of cas
+func loadCRLs(path string, cas []*x509.Certificate) ([]*x509.RevocationList, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ders [][]byte
+	for block, rest := pem.Decode(raw); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = append(ders, raw)
+	}
+
+	var crls []*x509.RevocationList
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CRL in %s: %w", path, err)
+		}
+		signed := false
+		for _, ca := range cas {
+			if bytes.Equal(ca.RawSubject, crl.RawIssuer) && crl.CheckSignatureFrom(ca) == nil {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			return nil, fmt.Errorf("CRL of %s in %s is not signed by a client CA", crl.Issuer, path)
+		}
+		if !crl.NextUpdate.IsZero() && crl.NextUpdate.Before(time.Now()) {
+			log.Warnf("CRL of %s in %s is outdated since %v", crl.Issuer, path, crl.NextUpdate)
+		}
+		crls = append(crls, crl)
+	}
+	return crls, nil
+}
+
+type ocspCacheEntry struct {
+	status  int // ocsp.Good, ocsp.Revoked or ocsp.Unknown
+	expires time.Time
+}
+
+// revocationChecker refuses the revoked client certificates, listed in a CRL or reported by
+// the OCSP responder of their issuer
+type revocationChecker struct {
+	crls         []*x509.RevocationList
+	ocsp         bool
+	ocspHardFail bool // refuse the certificates whose OCSP status can't be fetched
+	client       *http.Client
+
+	mutex sync.Mutex
+	cache map[string]ocspCacheEntry // by issuer and serial number
+}
+
+// newRevocationChecker returns nil when neither a CRL nor OCSP is enabled.
+// ocspMode is "" to disable OCSP, "soft" to accept the certificates whose status can't be fetched or "hard".
+func newRevocationChecker(crlFile, ocspMode string, cas []*x509.Certificate) (*revocationChecker, error) {
+	c := &revocationChecker{
+		client: &http.Client{Timeout: ocspTimeout},
+		cache:  make(map[string]ocspCacheEntry),
+	}
+	switch ocspMode {
+	case "":
+	case "soft":
+		c.ocsp = true
+	case "hard":
+		c.ocsp, c.ocspHardFail = true, true
+	default:
+		return nil, fmt.Errorf("invalid OCSP mode %s, expected soft or hard", ocspMode)
+	}
+	if len(crlFile) > 0 {
+		var err error
+		if c.crls, err = loadCRLs(crlFile, cas); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.crls) == 0 && !c.ocsp {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// verify is the tls.Config VerifyConnection callback. Unlike VerifyPeerCertificate it is also called
+// for the resumed sessions, whose certificates may have been revoked since the session was created.
+func (c *revocationChecker) verify(cs tls.ConnectionState) error {
+	chains := cs.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) < 2 {
+		return nil
+	}
+	leaf, issuer := chains[0][0], chains[0][1]
+	for _, crl := range c.crls {
+		if !bytes.Equal(crl.RawIssuer, leaf.RawIssuer) {
+			continue
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+				revocationFailures.inc("crl_revoked")
+				log.Warnf("Client certificate %s (serial %s) revoked by the CRL", leaf.Subject, leaf.SerialNumber)
+				return errors.New("client certificate revoked")
+			}
+		}
+	}
+	if !c.ocsp {
+		return nil
+	}
+
+	status, err := c.ocspStatus(leaf, issuer)
+	if err != nil {
+		revocationFailures.inc("ocsp_error")
+		if c.ocspHardFail {
+			log.Warnf("Client certificate %s refused, OCSP check failed: %v", leaf.Subject, err)
+			return fmt.Errorf("OCSP check failed: %w", err)
+		}
+		log.Debugf("OCSP check of client certificate %s failed, accepted anyway: %v", leaf.Subject, err)
+		return nil
+	}
+	switch status {
+	case ocsp.Revoked:
+		revocationFailures.inc("ocsp_revoked")
+		log.Warnf("Client certificate %s (serial %s) revoked by OCSP", leaf.Subject, leaf.SerialNumber)
+		return errors.New("client certificate revoked")
+	case ocsp.Unknown:
+		revocationFailures.inc("ocsp_unknown")
+		if c.ocspHardFail {
+			log.Warnf("Client certificate %s refused, unknown to the OCSP responder", leaf.Subject)
+			return errors.New("client certificate unknown to the OCSP responder")
+		}
+	}
+	return nil
+}
+
+// ocspStatus returns the status of leaf, from the cache or from the first OCSP responder of the certificate
+func (c *revocationChecker) ocspStatus(leaf, issuer *x509.Certificate) (int, error) {
+	sum := sha256.Sum256(issuer.Raw)
+	key := hex.EncodeToString(sum[:]) + ":" + leaf.SerialNumber.String()
+	now := time.Now()
+	c.mutex.Lock()
+	entry, ok := c.cache[key]
+	c.mutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.status, nil
+	}
+
+	if len(leaf.OCSPServer) == 0 {
+		return 0, errors.New("no OCSP responder in the certificate")
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return 0, err
+	}
+	httpResp, err := c.client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return 0, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("OCSP responder %s answered %s", leaf.OCSPServer[0], httpResp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	resp, err := ocsp.ParseResponseForCert(body, leaf, issuer)
+	if err != nil {
+		return 0, err
+	}
+
+	entry = ocspCacheEntry{status: resp.Status, expires: resp.NextUpdate}
+	if resp.NextUpdate.IsZero() {
+		entry.expires = now.Add(ocspDefaultTTL)
+	}
+	c.mutex.Lock()
+	for k, e := range c.cache {
+		if now.After(e.expires) {
+			delete(c.cache, k)
+		}
+	}
+	c.cache[key] = entry
+	c.mutex.Unlock()
+	log.Debugf("OCSP status of client certificate %s: %d, cached until %v", leaf.Subject, resp.Status, entry.expires)
+	return resp.Status, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestCert(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// handshake runs a TLS handshake over TCP, returning the error of the server and whether the session was resumed
+func handshake(t *testing.T, serverConf, clientConf *tls.Config) (bool, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	serverConn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverConn.Close()
+	done := make(chan struct{})
+	defer func() { <-done }()
+	go func() {
+		defer close(done)
+		client := tls.Client(clientConn, clientConf)
+		if client.Handshake() == nil {
+			// reads the session ticket
+			client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			client.Read(make([]byte, 1))
+		}
+		clientConn.Close()
+	}()
+	server := tls.Server(serverConn, serverConf)
+	if err := server.Handshake(); err != nil {
+		return false, err
+	}
+	// sends the session ticket
+	server.Write([]byte{0})
+	return server.ConnectionState().DidResume, nil
+}
+
+func TestRevocationOfResumedSessions(t *testing.T) {
+	now := time.Now()
+	ca, caKey := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "ca"},
+		NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil, nil)
+	server, serverKey := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "localhost"}, DNSNames: []string{"localhost"},
+		NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour), ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	client, clientKey := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "client"},
+		NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour), ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number: big.NewInt(1), ThisUpdate: now, NextUpdate: now.Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: client.SerialNumber, RevocationTime: now}},
+	}, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(crlDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	checker := &revocationChecker{}
+	serverConf := &tls.Config{
+		Certificates:     []tls.Certificate{{Certificate: [][]byte{server.Raw}, PrivateKey: serverKey}},
+		ClientCAs:        pool,
+		ClientAuth:       tls.RequireAndVerifyClientCert,
+		VerifyConnection: checker.verify,
+	}
+	clientConf := &tls.Config{
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{client.Raw}, PrivateKey: clientKey}},
+		RootCAs:            pool,
+		ServerName:         "localhost",
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+	if _, err := handshake(t, serverConf, clientConf); err != nil {
+		t.Fatalf("certificate not revoked yet refused: %v", err)
+	}
+	if resumed, err := handshake(t, serverConf, clientConf); err != nil || !resumed {
+		t.Fatalf("session not resumed: %v", err)
+	}
+
+	checker.crls = []*x509.RevocationList{crl}
+	if _, err := handshake(t, serverConf, clientConf); err == nil {
+		t.Fatal("resumed session of a revoked certificate accepted")
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configReloadDelay groups the events of one save of the config file, editors often write it in several steps
+const configReloadDelay = 200 * time.Millisecond
+
+// listValue is implemented by the values of the repeatable flags, which the config file replaces
+// as a whole, instead of adding its values to the ones of the environment
+type listValue interface {
+	flag.Value
+	replace(values []string)
+}
+
+// readConfigFile reads a file of "name = value" lines setting the flags of the same name.
+// The lines starting with # are comments, the repeatable flags can be given several times,
+// the other ones only once. The lines following a "[bind <address>]" header are the options
+// of this -bind entry, until the next header:
+//
+//	[bind 0.0.0.0:6121]
+//	www = /srv/a
+//	alpn = hq-interop,quicgo-echo
+func readConfigFile(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string][]string)
+	var bindAddr string // address of the [bind] section read, empty before the first one
+	var bindOpts url.Values
+	endSection := func() {
+		if len(bindAddr) > 0 {
+			bind := bindAddr
+			if len(bindOpts) > 0 {
+				bind += "?" + bindOpts.Encode()
+			}
+			values["bind"] = append(values["bind"], bind)
+		}
+	}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if header, ok := strings.CutPrefix(line, "["); ok {
+			section := strings.Fields(strings.TrimSuffix(header, "]"))
+			if !strings.HasSuffix(header, "]") || len(section) != 2 || section[0] != "bind" {
+				return nil, fmt.Errorf("%s:%d: expected [bind <address>]", path, n)
+			}
+			if flag.Lookup("bind") == nil {
+				return nil, fmt.Errorf("%s:%d: unknown setting bind", path, n)
+			}
+			endSection()
+			bindAddr, bindOpts = section[1], make(url.Values)
+			continue
+		}
+		name, value, found := strings.Cut(line, "=")
+		name = strings.TrimPrefix(strings.TrimSpace(name), "-")
+		if !found || len(name) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected name = value", path, n)
+		}
+		if len(bindAddr) > 0 {
+			// checked by parseBind
+			bindOpts.Add(name, strings.TrimSpace(value))
+			continue
+		}
+		f := flag.Lookup(name)
+		if name == "config" || f == nil {
+			return nil, fmt.Errorf("%s:%d: unknown setting %s", path, n, name)
+		}
+		if _, ok := f.Value.(listValue); !ok && len(values[name]) > 0 {
+			return nil, fmt.Errorf("%s:%d: %s already set", path, n, name)
+		}
+		values[name] = append(values[name], strings.TrimSpace(value))
+	}
+	endSection()
+	return values, scanner.Err()
+}
+
+// configFile sets the flags from a config file and applies its changes while the server runs.
+// The flags given on the command line take precedence over the file, which takes precedence
+// over the environment.
+type configFile struct {
+	path    string
+	cmdline map[string]bool     // flags set on the command line, ignored in the file
+	base    map[string]string   // values of the flags before the file was applied
+	applied map[string][]string // values of the file currently applied
+	// reloadable are the settings which can change at runtime, applied once the flags are set
+	reloadable map[string]func() error
+}
+
+// loadConfigFile sets the flags not given on the command line from the file at path, to call after flag.Parse
+func loadConfigFile(path string) (*configFile, error) {
+	c := &configFile{path: path, cmdline: make(map[string]bool), base: make(map[string]string)}
+	flag.Visit(func(f *flag.Flag) { c.cmdline[f.Name] = true })
+	flag.VisitAll(func(f *flag.Flag) { c.base[f.Name] = f.Value.String() })
+	values, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for name, vs := range values {
+		if c.cmdline[name] {
+			continue
+		}
+		if err := setConfigValue(name, vs); err != nil {
+			return nil, fmt.Errorf("%w in %s", err, path)
+		}
+	}
+	c.applied = values
+	return c, nil
+}
+
+// setConfigValue sets the flag name to the values of the file, replacing the list of a repeatable flag
+func setConfigValue(name string, values []string) error {
+	if list, ok := flag.Lookup(name).Value.(listValue); ok {
+		list.replace(values)
+		return nil
+	}
+	if err := flag.Set(name, values[0]); err != nil {
+		return fmt.Errorf("invalid value %q for %s: %w", values[0], name, err)
+	}
+	return nil
+}
+
+// reload applies the settings of the file which changed, and logs those requiring a restart
+func (c *configFile) reload() {
+	values, err := readConfigFile(c.path)
+	if err != nil {
+		log.Errorf("Unable to reload the config: %v", err)
+		return
+	}
+	var applied, pending []string
+	for name := range c.base {
+		vs, ok := values[name]
+		if !ok {
+			vs = []string{c.base[name]}
+		}
+		previous, ok := c.applied[name]
+		if !ok {
+			previous = []string{c.base[name]}
+		}
+		if c.cmdline[name] || strings.Join(vs, "\n") == strings.Join(previous, "\n") {
+			continue
+		}
+		apply, ok := c.reloadable[name]
+		if !ok {
+			pending = append(pending, name)
+			continue
+		}
+		if err := setConfigValue(name, vs); err != nil {
+			log.Errorf("%v in %s", err, c.path)
+			continue
+		}
+		if err := apply(); err != nil {
+			log.Errorf("Unable to apply the config setting %s: %v", name, err)
+			continue
+		}
+		applied = append(applied, name+"="+strings.Join(vs, ","))
+	}
+	c.applied = values
+	sort.Strings(applied)
+	sort.Strings(pending)
+	if len(applied) > 0 {
+		log.Infof("Config settings applied: %s", strings.Join(applied, ", "))
+	}
+	if len(pending) > 0 {
+		log.Warnf("Config settings changed which require a restart: %s", strings.Join(pending, ", "))
+	}
+}
+
+// watch reloads the file each time it is written, until ctx is done
+func (c *configFile) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// the directory is watched, the editors saving a file by renaming a new one over it
+	path, err := filepath.Abs(c.path)
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+	log.Infof("Watching %s for config changes", c.path)
+
+	timer := time.NewTimer(configReloadDelay)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-watcher.Events:
+			if ev.Name == path && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				timer.Reset(configReloadDelay)
+			}
+		case err := <-watcher.Errors:
+			log.Warnf("Watching %s failed: %v", c.path, err)
+		case <-timer.C:
+			c.reload()
+		}
+	}
+}
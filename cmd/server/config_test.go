@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// setTestFlags replaces the flags of the command line by -bind, -mount and -www for the test
+func setTestFlags(t *testing.T, args ...string) (*binds, *repeated, *string) {
+	t.Helper()
+	commandLine := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = commandLine })
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	bs, mounts := &binds{}, &repeated{}
+	flag.Var(bs, "bind", "")
+	flag.Var(mounts, "mount", "")
+	www := flag.String("www", "", "")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return bs, mounts, www
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server.conf")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		binds   []string
+		mounts  []string
+		www     string
+		invalid bool
+	}{
+		{
+			name:  "repeated bind lines",
+			file:  "bind = a:1\nbind = b:2,c:3\n",
+			binds: []string{"a:1", "b:2", "c:3"},
+		},
+		{
+			name:  "file replaces the environment",
+			env:   map[string]string{"QUICGO_BIND": "env:1", "QUICGO_MOUNT": "/env=/srv/env", "QUICGO_WWW": "/srv/env"},
+			file:  "bind = file:1\nmount = /a=/srv/a\nmount = /b=/srv/b\nwww = /srv/file\n",
+			binds: []string{"file:1"}, mounts: []string{"/a=/srv/a", "/b=/srv/b"}, www: "/srv/file",
+		},
+		{
+			name:  "environment without the setting in the file",
+			env:   map[string]string{"QUICGO_MOUNT": "/env=/srv/env"},
+			file:  "bind = file:1\n",
+			binds: []string{"file:1"}, mounts: []string{"/env=/srv/env"},
+		},
+		{
+			name:  "command line replaces the file",
+			args:  []string{"-mount", "/cmd=/srv/cmd", "-bind", "cmd:1"},
+			env:   map[string]string{"QUICGO_MOUNT": "/env=/srv/env"},
+			file:  "bind = file:1\nmount = /a=/srv/a\nwww = /srv/file\n",
+			binds: []string{"cmd:1"}, mounts: []string{"/cmd=/srv/cmd"}, www: "/srv/file",
+		},
+		{
+			name:  "comments",
+			file:  "# bind = a:1\n\n  -www = /srv/a  \n",
+			binds: []string{}, www: "/srv/a",
+		},
+		{
+			name:  "bind sections",
+			file:  "www = /srv/www\nbind = a:1\n[bind b:2]\nwww = /srv/b\nalpn = hq-interop,quicgo-echo\n\n[bind  c:3 ]\n",
+			binds: []string{"a:1", "b:2?alpn=hq-interop%2Cquicgo-echo&www=%2Fsrv%2Fb", "c:3"}, www: "/srv/www",
+		},
+		{name: "section without address", file: "[bind]\n", invalid: true},
+		{name: "unknown section", file: "[server a:1]\n", invalid: true},
+		{name: "scalar repeated", file: "www = /srv/a\nwww = /srv/b\n", invalid: true},
+		{name: "unknown setting", file: "unknown = 1\n", invalid: true},
+		{name: "config setting", file: "config = other.conf\n", invalid: true},
+		{name: "missing value", file: "www\n", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			bs, mounts, www := setTestFlags(t, test.args...)
+			if err := setFlagsFromEnv(); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadConfigFile(writeConfigFile(t, test.file))
+			if test.invalid {
+				if err == nil {
+					t.Fatal("invalid file accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(*bs, test.binds) {
+				t.Errorf("binds %q, expected %q", *bs, test.binds)
+			}
+			if !slices.Equal(*mounts, test.mounts) {
+				t.Errorf("mounts %q, expected %q", *mounts, test.mounts)
+			}
+			if *www != test.www {
+				t.Errorf("www %q, expected %q", *www, test.www)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+var connectionsRefused = newCounterVec("quicgo_connections_refused_total", "QUIC connections refused before the end of the handshake by reason", "reason")
+
+// limitHandshakesPerIP returns a copy of tlsConf failing the handshakes of the clients which started
+// more than maxHandshakes of them, the count of each IP decaying by maxHandshakes every window.
+// With Retry enabled only the validated addresses are counted, so spoofed packets can't use up the cap of a client.
+func limitHandshakesPerIP(tlsConf *tls.Config, maxHandshakes int, window time.Duration) *tls.Config {
+	limiter := newRateLimiter(float64(maxHandshakes)/window.Seconds(), maxHandshakes)
+	conf := tlsConf.Clone()
+	next := tlsConf.GetConfigForClient
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		// quic-go gives the client address with a fake net.Conn
+		addr := hello.Conn.RemoteAddr().String()
+		ip, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			ip = addr
+		}
+		if ok, _ := limiter.allow(ip, time.Now()); !ok {
+			connectionsRefused.inc("ip_cap")
+			log.Debugf("Handshake with %s refused, more than %d in %v", addr, maxHandshakes, window)
+			return nil, fmt.Errorf("too many handshakes from %s", ip)
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+	return conf
+}
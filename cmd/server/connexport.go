@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// connExportColumns are the columns of the rows written by connExport, one per closed connection, with their SQL type
+var connExportColumns = []struct{ name, sqlType string }{
+	{"start_time", "TEXT"}, {"end_time", "TEXT"}, {"duration_ms", "REAL"}, {"connection_id", "TEXT"}, {"peer", "TEXT"}, {"version", "TEXT"},
+	{"packets_sent", "INTEGER"}, {"bytes_sent", "INTEGER"}, {"packets_received", "INTEGER"}, {"bytes_received", "INTEGER"},
+	{"packets_lost", "INTEGER"}, {"loss_percent", "REAL"}, {"ptos", "INTEGER"},
+	{"min_rtt_ms", "REAL"}, {"smoothed_rtt_ms", "REAL"}, {"latest_rtt_ms", "REAL"}, {"rtt_deviation_ms", "REAL"}, {"close_reason", "TEXT"},
+}
+
+// connStatsExport appends the statistics of the closed connections to -conn-stats-file, nil when disabled
+var connStatsExport *connExport
+
+// connExport appends a row per closed connection to a CSV file or to the connections table of a SQLite
+// database, so that many runs can be analysed without their qlogs
+type connExport struct {
+	mutex  sync.Mutex
+	f      *os.File
+	csv    *csv.Writer
+	db     *sql.DB // nil for CSV
+	insert *sql.Stmt
+}
+
+// newConnExport opens path to append the rows in format: csv, with a header when the file is new, or sqlite
+func newConnExport(path, format string) (*connExport, error) {
+	switch format {
+	case "csv":
+		return newCSVExport(path)
+	case "sqlite":
+		return newSQLiteExport(path)
+	}
+	return nil, fmt.Errorf("unknown format %s, expected csv or sqlite", format)
+}
+
+func newCSVExport(path string) (*connExport, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	e := &connExport{f: f, csv: csv.NewWriter(f)}
+	if info.Size() == 0 {
+		header := make([]string, len(connExportColumns))
+		for i, c := range connExportColumns {
+			header[i] = c.name
+		}
+		e.csv.Write(header)
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// newSQLiteExport opens the SQLite database at path, created if needed with its connections table
+func newSQLiteExport(path string) (*connExport, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// the pragmas apply to the connection
+	db.SetMaxOpenConns(1)
+	columns := make([]string, len(connExportColumns))
+	placeholders := make([]string, len(connExportColumns))
+	for i, c := range connExportColumns {
+		columns[i] = c.name + " " + c.sqlType
+		placeholders[i] = "?"
+	}
+	// the database can be queried while the server writes to it
+	if _, err = db.Exec("PRAGMA busy_timeout = 5000"); err == nil {
+		_, err = db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS connections (%s)", strings.Join(columns, ", ")))
+	}
+	e := &connExport{db: db}
+	if err == nil {
+		e.insert, err = db.Prepare(fmt.Sprintf("INSERT INTO connections VALUES (%s)", strings.Join(placeholders, ", ")))
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to open the SQLite database %s: %w", path, err)
+	}
+	return e, nil
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// write appends the row of the connection closed with err
+func (e *connExport) write(connID string, s *connStats, err error) {
+	end := time.Now()
+	var lossPercent float64
+	if s.sentPackets > 0 {
+		lossPercent = float64(s.lostPackets) * 100 / float64(s.sentPackets)
+	}
+	var peer, reason string
+	if s.remote != nil {
+		peer = s.remote.String()
+	}
+	if err != nil {
+		reason = err.Error()
+	}
+	row := []string{
+		s.start.UTC().Format(time.RFC3339Nano), end.UTC().Format(time.RFC3339Nano), milliseconds(end.Sub(s.start)), connID, peer, s.version,
+		strconv.FormatUint(s.sentPackets, 10), strconv.FormatInt(int64(s.sentBytes), 10),
+		strconv.FormatUint(s.recvPackets, 10), strconv.FormatInt(int64(s.recvBytes), 10),
+		strconv.FormatUint(s.lostPackets, 10), strconv.FormatFloat(lossPercent, 'f', 3, 64), strconv.FormatUint(s.ptoCount, 10),
+		milliseconds(s.minRTT), milliseconds(s.smoothedRTT), milliseconds(s.latestRTT), milliseconds(s.rttDeviation), reason,
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.db != nil {
+		// the numbers are stored as such by the type affinity of their column
+		values := make([]any, len(row))
+		for i, v := range row {
+			values[i] = v
+		}
+		_, err = e.insert.Exec(values...)
+	} else {
+		e.csv.Write(row)
+		e.csv.Flush()
+		err = e.csv.Error()
+	}
+	if err != nil {
+		log.Errorf("Unable to export the statistics of connection %s: %v", connID, err)
+	}
+}
+
+func (e *connExport) close() error {
+	if e.db != nil {
+		e.insert.Close()
+		return e.db.Close()
+	}
+	return e.f.Close()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnExport(t *testing.T) {
+	stats := &connStats{
+		remote:      &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433},
+		sentPackets: 10, sentBytes: 12000, recvPackets: 8, recvBytes: 900, lostPackets: 1,
+		start: time.Now().Add(-time.Second), version: "v1", smoothedRTT: 25 * time.Millisecond,
+	}
+	dir := t.TempDir()
+
+	t.Run("csv", func(t *testing.T) {
+		path := filepath.Join(dir, "conns.csv")
+		// the header is only written to the new files
+		for i := 0; i < 2; i++ {
+			e, err := newConnExport(path, "csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.write("0102", stats, errors.New("it's closed"))
+			e.close()
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+		if len(lines) != 3 || !strings.HasPrefix(lines[0], "start_time,") || !strings.Contains(lines[2], ",0102,192.0.2.1:4433,v1,10,12000,8,900,1,10.000,") {
+			t.Errorf("unexpected CSV:\n%s", raw)
+		}
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		path := filepath.Join(dir, "conns.db")
+		for i := 0; i < 2; i++ {
+			e, err := newConnExport(path, "sqlite")
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.write("0102", stats, errors.New("it's closed"))
+			e.close()
+		}
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		var count, bytesSent int64
+		var loss, rtt float64
+		var reason string
+		err = db.QueryRow("SELECT COUNT(*), MAX(bytes_sent), MAX(loss_percent), MAX(smoothed_rtt_ms), MAX(close_reason) FROM connections WHERE typeof(bytes_sent) = 'integer'").
+			Scan(&count, &bytesSent, &loss, &rtt, &reason)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 || bytesSent != 12000 || loss != 10 || rtt != 25 || reason != "it's closed" {
+			t.Errorf("rows %d, bytes_sent %d, loss_percent %v, smoothed_rtt_ms %v, close_reason %q", count, bytesSent, loss, rtt, reason)
+		}
+	})
+
+	if _, err := newConnExport(filepath.Join(dir, "conns.sql"), "sql-script"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// writePidFile writes the pid of the process to path
+func writePidFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePidFile removes the pid file at path, unless it holds the pid of another process such as the one of a binary upgrade.
+// Once the privileges are dropped, the directory of the file may no longer be writable: the file is then emptied instead.
+func removePidFile(path string) error {
+	pid, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if string(bytes.TrimSpace(pid)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, fs.ErrPermission) && os.Truncate(path, 0) == nil {
+			return fmt.Errorf("%w, emptied it instead, use a directory writable by -user", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// redirectOutput sends everything written to std (os.Stdout or os.Stderr) to the file at path, opened in append mode
+func redirectOutput(path string, std **os.File) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	return redirectFile(f, std)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func redirectFile(f *os.File, std **os.File) error {
+	*std = f
+	return nil
+}
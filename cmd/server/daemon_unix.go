@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// redirectFile duplicates f over the descriptor of std, so that runtime panics are captured as well
+func redirectFile(f *os.File, std **os.File) error {
+	defer f.Close()
+	return unix.Dup2(int(f.Fd()), int((*std).Fd()))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// datagramQueueSize is the number of datagrams of a request waiting to be echoed, the others are dropped
+const datagramQueueSize = 64
+
+// datagramSessions dispatches the HTTP datagrams (RFC 9297) received on the QUIC connections to the requests
+// they are associated with, by the quarter stream ID they start with
+type datagramSessions struct {
+	mutex sync.Mutex
+	conns map[quic.Connection]map[uint64]chan []byte
+}
+
+var h3Datagrams = &datagramSessions{conns: make(map[quic.Connection]map[uint64]chan []byte)}
+
+// open registers the request of the quarter stream ID id, the connection being read from its first one
+func (d *datagramSessions) open(conn quic.Connection, id uint64) chan []byte {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	sessions, ok := d.conns[conn]
+	if !ok {
+		sessions = make(map[uint64]chan []byte)
+		d.conns[conn] = sessions
+		go d.receive(conn, sessions)
+	}
+	ch := make(chan []byte, datagramQueueSize)
+	sessions[id] = ch
+	return ch
+}
+
+func (d *datagramSessions) close(conn quic.Connection, id uint64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if sessions, ok := d.conns[conn]; ok {
+		delete(sessions, id)
+	}
+}
+
+// receive reads the datagrams of conn until it is closed, those of no open request are dropped
+func (d *datagramSessions) receive(conn quic.Connection, sessions map[uint64]chan []byte) {
+	l := connLogger(conn)
+	for {
+		b, err := conn.ReceiveDatagram(conn.Context())
+		if err != nil {
+			break
+		}
+		id, n, ok := varint(b)
+		if !ok {
+			l.Debugf("Datagram of %d bytes without quarter stream ID from %s", len(b), conn.RemoteAddr())
+			continue
+		}
+		d.mutex.Lock()
+		ch, ok := sessions[id]
+		d.mutex.Unlock()
+		if !ok {
+			l.Debugf("Datagram of %d bytes for the unknown stream %d from %s", len(b), id*4, conn.RemoteAddr())
+			continue
+		}
+		select {
+		case ch <- b[n:]:
+		default:
+		}
+	}
+	d.mutex.Lock()
+	delete(d.conns, conn)
+	d.mutex.Unlock()
+}
+
+// datagramHandler echoes the HTTP datagrams associated with the request until its body ends, dropping
+// loss percent of them on purpose, then writes how many were received and echoed
+func datagramHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := w.(http3.Hijacker)
+	streamer, ok2 := r.Body.(http3.HTTPStreamer)
+	if !ok || !ok2 {
+		http.Error(w, "HTTP/3 required", http.StatusBadRequest)
+		return
+	}
+	conn, ok := h.StreamCreator().(quic.Connection)
+	if !ok || !conn.ConnectionState().SupportsDatagrams {
+		http.Error(w, "QUIC datagrams not negotiated, the client must enable the HTTP/3 datagrams", http.StatusBadRequest)
+		return
+	}
+	var loss float64
+	if s := r.URL.Query().Get("loss"); len(s) > 0 {
+		var err error
+		if loss, err = strconv.ParseFloat(s, 64); err != nil || loss < 0 || loss > 100 {
+			http.Error(w, "loss must be a percentage", http.StatusBadRequest)
+			return
+		}
+	}
+	l := loggerFrom(r.Context())
+	// the stream is hijacked from here, the server neither flushes nor closes it
+	str := streamer.HTTPStream()
+	defer str.Close()
+	id := uint64(str.StreamID()) / 4
+	ch := h3Datagrams.open(conn, id)
+	defer h3Datagrams.close(conn, id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	// the end of the request body ends the session
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, r.Body)
+		close(done)
+	}()
+	var summary struct {
+		Received int `json:"received"`
+		Echoed   int `json:"echoed"`
+		Dropped  int `json:"dropped"`
+	}
+loop:
+	for {
+		select {
+		case payload := <-ch:
+			summary.Received++
+			if rand.Float64()*100 < loss {
+				summary.Dropped++
+				continue
+			}
+			if err := conn.SendDatagram(append(quicvarint.Append(nil, id), payload...)); err != nil {
+				l.Debugf("Unable to echo a datagram of %d bytes to %s: %v", len(payload), r.RemoteAddr, err)
+				summary.Dropped++
+				continue
+			}
+			summary.Echoed++
+		case <-done:
+			break loop
+		case <-conn.Context().Done():
+			return
+		}
+	}
+	l.Debugf("Datagram session of %s closed: %d received, %d echoed, %d dropped", r.RemoteAddr, summary.Received, summary.Echoed, summary.Dropped)
+	json.NewEncoder(w).Encode(summary)
+	w.(http.Flusher).Flush()
+}
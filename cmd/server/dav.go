@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/net/webdav"
+)
+
+// davPrefix is the URL prefix under which the WebDAV share is mounted
+const davPrefix = "/dav"
+
+func newDavHandler(dir string) http.Handler {
+	return &webdav.Handler{
+		Prefix:     davPrefix,
+		FileSystem: webdav.Dir(dir),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				loggerFrom(r.Context()).Debugf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// parseRoutes parses a comma separated list of path prefixes
+func parseRoutes(v string) []string {
+	var routes []string
+	for _, route := range strings.Split(v, ",") {
+		if route = strings.TrimSpace(route); len(route) > 0 {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// isEarlyData tells if r may have been sent in 0-RTT, before the client proved it is not replaying
+// a recorded connection. Requests forwarded by a proxy in early data carry "Early-Data: 1" (RFC 8470).
+func isEarlyData(r *http.Request) bool {
+	return (r.TLS != nil && !r.TLS.HandshakeComplete) || r.Header.Get("Early-Data") == "1"
+}
+
+// rejectUnsafeEarlyData answers 425 Too Early to the early data requests whose method is not safe,
+// as a replay could apply them twice, except on the routes starting with one of the prefixes of allowed.
+// The clients are expected to send them again once the handshake is complete.
+func rejectUnsafeEarlyData(allowed []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if isEarlyData(r) && !hasPrefix(r.URL.Path, allowed) {
+				log.Debugf("%s %s of %s rejected, sent in 0-RTT", r.Method, r.URL.Path, r.RemoteAddr)
+				http.Error(w, "Too Early", http.StatusTooEarly)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func hasPrefix(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/websocket"
+)
+
+// echoALPN is the ALPN of the raw QUIC echo protocol: the data of every bidirectional stream is sent back on it
+const echoALPN = "quicgo-echo"
+
+// maxEchoStream is the size of the data echoed on one stream
+const maxEchoStream = 1 << 20
+
+// serveEcho serves the echo protocol on the connections of ln
+func serveEcho(ln http3.QUICEarlyListener) error {
+	for {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return err
+		}
+		go func() {
+			for {
+				str, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					log.Debugf("Accepting echo stream failed: %v", err)
+					return
+				}
+				go func() {
+					n, err := io.Copy(str, io.LimitReader(str, maxEchoStream))
+					if err != nil {
+						log.Debugf("Echo on stream %d failed: %v", str.StreamID(), err)
+						str.CancelWrite(0)
+						return
+					}
+					log.Debugf("Echoed %d bytes to %s on stream %d", n, conn.RemoteAddr(), str.StreamID())
+					str.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// echoMessageHandler sends back the body of a POST request, the HTTP/3 counterpart of the WebSocket echo
+func echoMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// read before answering, to refuse the bodies over -max-request-body with 413
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxEchoStream))
+	if err != nil {
+		if !bodyTooLarge(w, err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(body)
+}
+
+// echoHandler streams the request body back while it is received. The method and the request
+// headers listed in ?headers= (all of them by default) are reflected in X-Echo-* response headers,
+// the request trailers in response trailers. quic-go does not support the trailers yet:
+// they are only echoed over the TCP fallback.
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("X-Echo-Method", r.Method)
+	var names []string
+	if v := r.URL.Query().Get("headers"); len(v) > 0 {
+		names = strings.Split(v, ",")
+	} else {
+		for name := range r.Header {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		for _, v := range r.Header.Values(name) {
+			h.Add("X-Echo-"+name, v)
+		}
+	}
+	contentType := r.Header.Get("Content-Type")
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+
+	// HTTP/1.1 does not let the handlers read the body once the response started by default
+	rc := http.NewResponseController(w)
+	rc.EnableFullDuplex()
+	w.WriteHeader(http.StatusOK)
+	buf := make([]byte, benchBufferSize)
+	for {
+		n, err := r.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// past -max-request-body too: the response is already started, it ends there
+			loggerFrom(r.Context()).Debugf("Reading the echo body of %s failed: %v", r.RemoteAddr, err)
+			return
+		}
+	}
+	for name, vs := range r.Trailer {
+		for _, v := range vs {
+			h.Add(http.TrailerPrefix+name, v)
+		}
+	}
+}
+
+// echoWebsocketHandler sends back every message of a WebSocket, for the clients of the TCP listener
+var echoWebsocketHandler = websocket.Handler(func(ws *websocket.Conn) {
+	defer ws.Close()
+	ws.MaxPayloadBytes = maxEchoStream
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return
+		}
+		if err := websocket.Message.Send(ws, msg); err != nil {
+			return
+		}
+	}
+})
+
+const echoPage = `<!DOCTYPE html>
+<html><head><title>quicgo echo</title></head>
+<body>
+<h1>Echo</h1>
+<p>Transport: <span id="transport"></span></p>
+<form id="form"><input id="msg" size="60" autocomplete="off"> <button>Send</button></form>
+<pre id="log"></pre>
+<script>` + demoTransportScript + `
+const log = document.getElementById("log");
+const show = (msg, start) => { log.textContent += msg + " (" + Math.round(performance.now() - start) + " ms)\n"; };
+let send;
+if (useWebSocket) {
+	const ws = new WebSocket(wsURL("/ws/echo"));
+	const pending = [];
+	ws.onmessage = e => show(e.data, pending.shift());
+	send = msg => { pending.push(performance.now()); ws.send(msg); };
+} else {
+	send = msg => {
+		const start = performance.now();
+		fetch("/demo/echo/message", {method: "POST", body: msg}).then(r => r.text()).then(t => show(t, start));
+	};
+}
+document.getElementById("form").onsubmit = e => {
+	e.preventDefault();
+	const input = document.getElementById("msg");
+	if (input.value) send(input.value);
+	input.value = "";
+};
+</script>
+</body></html>
+`
+
+func echoPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, echoPage)
+}
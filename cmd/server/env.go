@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is the prefix of the environment variables matching the flags
+const envPrefix = "QUICGO_"
+
+// flagEnvName returns the environment variable matching a flag, e.g. QUICGO_CERT_FILE for -cert-file
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// setFlagsFromEnv sets the flags not given on the command line from their environment variable, if
+// present. It must be called after flag.Parse, the command line replacing the environment even for the
+// repeatable flags such as -bind.
+func setFlagsFromEnv() error {
+	cmdline := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(flagEnvName(f.Name))
+		if !ok || cmdline[f.Name] || err != nil {
+			return
+		}
+		if setErr := f.Value.Set(v); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", v, flagEnvName(f.Name), setErr)
+		}
+	})
+	return err
+}
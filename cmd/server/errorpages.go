@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxErrorMessage is the size of the handler text kept as the message of an error page
+const maxErrorMessage = 1 << 10
+
+const defaultErrorTemplate = `<!DOCTYPE html>
+<html><head><title>{{.Status}} {{.StatusText}}</title></head>
+<body><h1>{{.Status}} {{.StatusText}}</h1>{{if .Message}}<p>{{.Message}}</p>{{end}}<hr><p>{{.Method}} {{.Path}}</p></body></html>
+`
+
+// errorPageData is given to the error page templates
+type errorPageData struct {
+	Status     int    `json:"status"`
+	StatusText string `json:"error"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Message    string `json:"message,omitempty"` // text written by the handler, e.g. with http.Error
+}
+
+// errorPages renders the error responses with the templates of a directory (404.html, 4xx.html,
+// error.html, looked up in this order) or with an inline template
+type errorPages struct {
+	dir    string
+	inline *template.Template
+}
+
+func newErrorPages(dir, inline string) (*errorPages, error) {
+	if len(dir) == 0 && len(inline) == 0 {
+		return nil, nil
+	}
+	p := &errorPages{dir: dir}
+	if len(dir) > 0 {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("error pages directory %s not found", dir)
+		}
+	}
+	text := defaultErrorTemplate
+	if len(inline) > 0 {
+		text = inline
+	}
+	var err error
+	if p.inline, err = template.New("error").Parse(text); err != nil {
+		return nil, fmt.Errorf("invalid error page template: %w", err)
+	}
+	return p, nil
+}
+
+// lookup returns the template of status, the files are parsed on each error so they can be edited live
+func (p *errorPages) lookup(status int) (*template.Template, error) {
+	if len(p.dir) > 0 {
+		for _, name := range []string{strconv.Itoa(status) + ".html", strconv.Itoa(status/100) + "xx.html", "error.html"} {
+			t, err := template.ParseFiles(filepath.Join(p.dir, name))
+			if err == nil {
+				return t, nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+	return p.inline, nil
+}
+
+// wantsJSON tells if the client prefers a JSON body to an HTML one
+func wantsJSON(r *http.Request) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			jsonQ = max(jsonQ, q)
+		case mediaType == "text/html" || mediaType == "*/*" || mediaType == "text/*":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+func (p *errorPages) render(w http.ResponseWriter, r *http.Request, status int, message string) {
+	data := errorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Message:    strings.TrimSpace(message),
+	}
+	body := &bytes.Buffer{}
+	contentType := "text/html; charset=utf-8"
+	if wantsJSON(r) {
+		contentType = "application/json"
+		json.NewEncoder(body).Encode(data)
+	} else {
+		t, err := p.lookup(status)
+		if err == nil {
+			err = t.Execute(body, data)
+		}
+		if err != nil {
+			log.Errorf("Unable to render the error page of status %d: %v", status, err)
+			body.Reset()
+			template.Must(template.New("error").Parse(defaultErrorTemplate)).Execute(body, data)
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body.Bytes())
+	}
+}
+
+// handler replaces the bodies of the error responses of next. Responses already carrying
+// a rich body, i.e. anything else than plain text, are left untouched.
+func (p *errorPages) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorPageWriter{ResponseWriter: w}
+		next.ServeHTTP(keepHijacker(w, ew), r)
+		if ew.status != 0 {
+			p.render(w, r, ew.status, ew.message.String())
+		}
+	})
+}
+
+// errorPageWriter holds back the error responses with a plain text body, so they can be rendered
+type errorPageWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int // status of the held back error response, 0 when forwarded
+	message     bytes.Buffer
+}
+
+func (w *errorPageWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	contentType := w.Header().Get("Content-Type")
+	if status >= 400 && (contentType == "" || strings.HasPrefix(contentType, "text/plain")) {
+		w.status = status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorPageWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status != 0 {
+		w.message.Write(p[:min(len(p), max(maxErrorMessage-w.message.Len(), 0))])
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *errorPageWriter) Flush() {
+	if w.status != 0 {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// maxInjectedDelay bounds the delay a client can request with X-Inject-Delay
+const maxInjectedDelay = time.Minute
+
+// faultMux registers handlers on a mux honoring the fault injection headers
+type faultMux struct {
+	*http.ServeMux
+}
+
+func (m faultMux) Handle(pattern string, handler http.Handler) {
+	m.ServeMux.Handle(pattern, injectFaults(handler))
+}
+
+func (m faultMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// injectErrorCode parses the HTTP/3 error code of a fault header, decimal or 0x hexadecimal, def when it has none
+func injectErrorCode(v string, def http3.ErrCode) uint64 {
+	code, err := strconv.ParseUint(v, 0, 62)
+	if err != nil {
+		return uint64(def)
+	}
+	return code
+}
+
+// injectFaults lets the clients trigger failures of next deterministically:
+//   - X-Inject-Delay: duration to wait before anything else, e.g. 500ms
+//   - X-Inject-Close-Connection: close the connection, with the HTTP/3 error code given (H3_NO_ERROR by default)
+//   - X-Inject-Reset-Stream: reset the request stream, with the HTTP/3 error code given (H3_REQUEST_CANCELLED by default)
+//   - X-Inject-Status: answer with this status code instead of calling next
+//
+// Over the TCP fallback, the stream is reset by aborting the handler and the connection is closed by hijacking it,
+// which HTTP/2 does not allow: the stream is reset instead.
+func injectFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("X-Inject-Delay"); len(v) > 0 {
+			delay, err := time.ParseDuration(v)
+			if err != nil || delay < 0 || delay > maxInjectedDelay {
+				http.Error(w, "invalid X-Inject-Delay", http.StatusBadRequest)
+				return
+			}
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if v, ok := r.Header["X-Inject-Close-Connection"]; ok {
+			code := injectErrorCode(v[0], http3.ErrCodeNoError)
+			loggerFrom(r.Context()).Debugf("Closing the connection of %s on request (error code %#x)", r.RemoteAddr, code)
+			if h, ok := w.(http3.Hijacker); ok {
+				if conn, ok := h.StreamCreator().(quic.Connection); ok {
+					conn.CloseWithError(quic.ApplicationErrorCode(code), "injected fault")
+					return
+				}
+			}
+			if h, ok := w.(http.Hijacker); ok {
+				if conn, _, err := h.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			panic(http.ErrAbortHandler)
+		}
+
+		if v, ok := r.Header["X-Inject-Reset-Stream"]; ok {
+			code := injectErrorCode(v[0], http3.ErrCodeRequestCanceled)
+			loggerFrom(r.Context()).Debugf("Resetting the stream of %s %s on request (error code %#x)", r.Method, r.RequestURI, code)
+			if s, ok := r.Body.(http3.HTTPStreamer); ok {
+				str := s.HTTPStream()
+				str.CancelRead(quic.StreamErrorCode(code))
+				str.CancelWrite(quic.StreamErrorCode(code))
+				return
+			}
+			panic(http.ErrAbortHandler)
+		}
+
+		if v := r.Header.Get("X-Inject-Status"); len(v) > 0 {
+			status, err := strconv.Atoi(v)
+			if err != nil || status < 200 || status > 599 {
+				http.Error(w, "invalid X-Inject-Status", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
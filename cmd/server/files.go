@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// fileEntry describes one regular file of the www directory in the /api/files listing
+type fileEntry struct {
+	Name   string    `json:"name"`
+	Size   int64     `json:"size"`
+	Mtime  time.Time `json:"mtime"`
+	Sha256 string    `json:"sha256,omitempty"`
+}
+
+func fileSha256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// listFiles walks the www tree and returns all regular files, with paths relative to www
+func listFiles(www string, withSha256 bool) ([]fileEntry, error) {
+	entries := []fileEntry{}
+	err := filepath.WalkDir(www, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(www, path)
+		if err != nil {
+			return err
+		}
+
+		entry := fileEntry{
+			Name:  filepath.ToSlash(rel),
+			Size:  info.Size(),
+			Mtime: info.ModTime().UTC(),
+		}
+		if withSha256 {
+			if entry.Sha256, err = fileSha256(path); err != nil {
+				return err
+			}
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// filesHandler serves the JSON listing of the www directory.
+// The sha256 digest of each file is only computed when the sha256 query parameter is set.
+func filesHandler(www string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		withSha256, _ := strconv.ParseBool(r.URL.Query().Get("sha256"))
+		entries, err := listFiles(www, withSha256)
+		if err != nil {
+			log.Errorf("Unable to list files in %s: %v", www, err)
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
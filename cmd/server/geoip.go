@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// unknownCountry is the country code of the addresses missing from the database, e.g. the private ones
+const unknownCountry = "--"
+
+// geoInfo is what the MaxMind databases know about a client address
+type geoInfo struct {
+	country string // ISO 3166-1 alpha-2 code, unknownCountry when not found
+	asn     uint
+	org     string
+}
+
+func (g geoInfo) String() string {
+	if g.asn == 0 {
+		return g.country
+	}
+	return fmt.Sprintf("%s AS%d %s", g.country, g.asn, g.org)
+}
+
+// geoIP tags the clients with the MaxMind country (or city) and ASN databases, and
+// filters them by country
+type geoIP struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader // nil when no ASN database is given
+	rules   atomic.Pointer[countryRules]
+}
+
+// countryRules filter the clients by country, they can be replaced while the server runs
+type countryRules struct {
+	allow map[string]bool // countries allowed, all but the denied ones when empty
+	deny  map[string]bool
+}
+
+func parseCountries(v string) map[string]bool {
+	countries := make(map[string]bool)
+	for _, c := range strings.Split(v, ",") {
+		if c = strings.ToUpper(strings.TrimSpace(c)); len(c) > 0 {
+			countries[c] = true
+		}
+	}
+	return countries
+}
+
+// newGeoIP opens the databases. It returns nil when no country database is given.
+func newGeoIP(countryDB, asnDB, allow, deny string) (*geoIP, error) {
+	if len(countryDB) == 0 {
+		if len(asnDB) > 0 || len(allow) > 0 || len(deny) > 0 {
+			return nil, fmt.Errorf("the GeoIP options require -geoip-db")
+		}
+		return nil, nil
+	}
+	g := &geoIP{}
+	g.setRules(allow, deny)
+	var err error
+	if g.country, err = maxminddb.Open(countryDB); err != nil {
+		return nil, fmt.Errorf("unable to open the GeoIP database %s: %w", countryDB, err)
+	}
+	if len(asnDB) > 0 {
+		if g.asn, err = maxminddb.Open(asnDB); err != nil {
+			g.country.Close()
+			return nil, fmt.Errorf("unable to open the GeoIP ASN database %s: %w", asnDB, err)
+		}
+	}
+	log.Infof("GeoIP database %s loaded (%s)", countryDB, g.country.Metadata.DatabaseType)
+	return g, nil
+}
+
+func (g *geoIP) lookup(ip net.IP) geoInfo {
+	info := geoInfo{country: unknownCountry}
+	var country struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := g.country.Lookup(ip, &country); err != nil {
+		log.Debugf("GeoIP lookup of %s failed: %v", ip, err)
+	} else if len(country.Country.ISOCode) > 0 {
+		info.country = country.Country.ISOCode
+	}
+	if g.asn != nil {
+		var asn struct {
+			Number uint   `maxminddb:"autonomous_system_number"`
+			Org    string `maxminddb:"autonomous_system_organization"`
+		}
+		if err := g.asn.Lookup(ip, &asn); err != nil {
+			log.Debugf("GeoIP ASN lookup of %s failed: %v", ip, err)
+		}
+		info.asn, info.org = asn.Number, asn.Org
+	}
+	return info
+}
+
+// lookupAddr looks up the IP of a host:port request remote address
+func (g *geoIP) lookupAddr(addr string) geoInfo {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return geoInfo{country: unknownCountry}
+	}
+	return g.lookup(ip)
+}
+
+// setRules replaces the comma separated countries allowed and denied
+func (g *geoIP) setRules(allow, deny string) {
+	g.rules.Store(&countryRules{allow: parseCountries(allow), deny: parseCountries(deny)})
+}
+
+func (rules *countryRules) allowed(country string) bool {
+	if rules.deny[country] {
+		return false
+	}
+	return len(rules.allow) == 0 || rules.allow[country]
+}
+
+// handler rejects the requests of the clients whose country is not allowed
+func (g *geoIP) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules := g.rules.Load()
+		if len(rules.allow) == 0 && len(rules.deny) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if info := g.lookupAddr(r.RemoteAddr); !rules.allowed(info.country) {
+			log.Debugf("Request of %s denied by the GeoIP rules (%s)", r.RemoteAddr, info)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
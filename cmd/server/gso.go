@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// gsoListener logs whether the UDP segmentation offload is used when the first connection is accepted,
+// quic-go only reports it in the connection state
+type gsoListener struct {
+	http3.QUICEarlyListener
+	once sync.Once
+}
+
+func (l *gsoListener) Accept(ctx context.Context) (quic.EarlyConnection, error) {
+	conn, err := l.QUICEarlyListener.Accept(ctx)
+	if err == nil {
+		l.once.Do(func() {
+			log.Infof("UDP segmentation offload (GSO) enabled on %s: %t", l.Addr(), conn.ConnectionState().GSO)
+		})
+	}
+	return conn, err
+}
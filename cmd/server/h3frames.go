@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// maxLoggedFramePayload bounds the payload of the control frames decoded to be logged
+const maxLoggedFramePayload = 4096
+
+// h3FrameNames are the names of the HTTP/3 frame types (RFC 9114 and RFC 9218)
+var h3FrameNames = map[uint64]string{
+	0x0:     "DATA",
+	0x1:     "HEADERS",
+	0x3:     "CANCEL_PUSH",
+	0x4:     "SETTINGS",
+	0x5:     "PUSH_PROMISE",
+	0x7:     "GOAWAY",
+	0xd:     "MAX_PUSH_ID",
+	0xf0700: "PRIORITY_UPDATE",
+	0xf0701: "PRIORITY_UPDATE(push)",
+}
+
+// h3SettingNames are the names of the HTTP/3 settings
+var h3SettingNames = map[uint64]string{
+	0x1:        "QPACK_MAX_TABLE_CAPACITY",
+	0x6:        "MAX_FIELD_SECTION_SIZE",
+	0x7:        "QPACK_BLOCKED_STREAMS",
+	0x8:        "ENABLE_CONNECT_PROTOCOL",
+	0x33:       "H3_DATAGRAM",
+	0x2b603742: "ENABLE_WEBTRANSPORT",
+}
+
+// h3UniStreamNames are the names of the types of the unidirectional streams
+var h3UniStreamNames = map[uint64]string{
+	0x0:  "control",
+	0x1:  "push",
+	0x2:  "QPACK encoder",
+	0x3:  "QPACK decoder",
+	0x54: "WebTransport",
+}
+
+// h3FrameLogListener logs at debug level the HTTP/3 frames of the connections it accepts, sent and received.
+// It reads them from the bytes of the streams, quic-go does not trace them.
+type h3FrameLogListener struct {
+	http3.QUICEarlyListener
+}
+
+func (l *h3FrameLogListener) Accept(ctx context.Context) (quic.EarlyConnection, error) {
+	conn, err := l.QUICEarlyListener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &h3FrameLogConn{EarlyConnection: conn, log: connLogger(conn)}, nil
+}
+
+// h3FrameLogConn wraps the streams of a connection to parse their frames
+type h3FrameLogConn struct {
+	quic.EarlyConnection
+	log logger
+}
+
+func (c *h3FrameLogConn) wrap(str quic.Stream) quic.Stream {
+	return &h3FrameLogStream{
+		Stream: str,
+		recv:   &h3FrameParser{log: c.log, streamID: str.StreamID(), direction: "received"},
+		sent:   &h3FrameParser{log: c.log, streamID: str.StreamID(), direction: "sent"},
+	}
+}
+
+func (c *h3FrameLogConn) AcceptStream(ctx context.Context) (quic.Stream, error) {
+	str, err := c.EarlyConnection.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.wrap(str), nil
+}
+
+func (c *h3FrameLogConn) OpenStream() (quic.Stream, error) {
+	str, err := c.EarlyConnection.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return c.wrap(str), nil
+}
+
+func (c *h3FrameLogConn) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	str, err := c.EarlyConnection.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.wrap(str), nil
+}
+
+func (c *h3FrameLogConn) AcceptUniStream(ctx context.Context) (quic.ReceiveStream, error) {
+	str, err := c.EarlyConnection.AcceptUniStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &h3FrameLogReceiveStream{ReceiveStream: str, recv: &h3FrameParser{log: c.log, streamID: str.StreamID(), direction: "received", uni: true}}, nil
+}
+
+func (c *h3FrameLogConn) OpenUniStream() (quic.SendStream, error) {
+	str, err := c.EarlyConnection.OpenUniStream()
+	if err != nil {
+		return nil, err
+	}
+	return &h3FrameLogSendStream{SendStream: str, sent: &h3FrameParser{log: c.log, streamID: str.StreamID(), direction: "sent", uni: true}}, nil
+}
+
+func (c *h3FrameLogConn) OpenUniStreamSync(ctx context.Context) (quic.SendStream, error) {
+	str, err := c.EarlyConnection.OpenUniStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &h3FrameLogSendStream{SendStream: str, sent: &h3FrameParser{log: c.log, streamID: str.StreamID(), direction: "sent", uni: true}}, nil
+}
+
+type h3FrameLogStream struct {
+	quic.Stream
+	recv, sent *h3FrameParser
+}
+
+func (s *h3FrameLogStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	s.recv.feed(p[:n])
+	return n, err
+}
+
+func (s *h3FrameLogStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	s.sent.feed(p[:n])
+	return n, err
+}
+
+type h3FrameLogReceiveStream struct {
+	quic.ReceiveStream
+	recv *h3FrameParser
+}
+
+func (s *h3FrameLogReceiveStream) Read(p []byte) (int, error) {
+	n, err := s.ReceiveStream.Read(p)
+	s.recv.feed(p[:n])
+	return n, err
+}
+
+type h3FrameLogSendStream struct {
+	quic.SendStream
+	sent *h3FrameParser
+}
+
+func (s *h3FrameLogSendStream) Write(p []byte) (int, error) {
+	n, err := s.SendStream.Write(p)
+	s.sent.feed(p[:n])
+	return n, err
+}
+
+// h3FrameParser logs the frames of one direction of a stream from its bytes, in whatever chunks they come
+type h3FrameParser struct {
+	log       logger
+	streamID  quic.StreamID
+	direction string
+	uni       bool // the stream starts with its type
+
+	typed     bool   // the type of the unidirectional stream is read
+	header    []byte // bytes of the frame header read so far
+	frameType uint64
+	length    uint64
+	remaining uint64 // bytes of the payload still to come
+	payload   []byte // payload of a control frame, decoded once complete
+	decode    bool
+	done      bool // the rest of the stream is not made of frames
+}
+
+// varint decodes the QUIC variable-length integer at the start of b, false when b is too short
+func varint(b []byte) (uint64, int, bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0, false
+	}
+	v := uint64(b[0] & 0x3f)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n, true
+}
+
+func (p *h3FrameParser) feed(b []byte) {
+	for len(b) > 0 && !p.done {
+		if p.remaining > 0 {
+			n := uint64(len(b))
+			if n > p.remaining {
+				n = p.remaining
+			}
+			if p.decode {
+				p.payload = append(p.payload, b[:n]...)
+			}
+			b = b[n:]
+			if p.remaining -= n; p.remaining == 0 && p.decode {
+				p.logFrame()
+			}
+			continue
+		}
+
+		p.header = append(p.header, b[0])
+		b = b[1:]
+		if p.uni && !p.typed {
+			streamType, _, ok := varint(p.header)
+			if !ok {
+				continue
+			}
+			p.typed = true
+			p.header = p.header[:0]
+			name, known := h3UniStreamNames[streamType]
+			if !known {
+				name = fmt.Sprintf("%#x", streamType)
+			}
+			p.log.Debugf("HTTP/3 stream %d %s is a %s stream", p.streamID, p.direction, name)
+			// only the control and push streams carry frames
+			p.done = streamType != 0x0 && streamType != 0x1
+			continue
+		}
+		frameType, n, ok := varint(p.header)
+		if !ok {
+			continue
+		}
+		length, _, ok := varint(p.header[n:])
+		if !ok {
+			continue
+		}
+		p.header = p.header[:0]
+		if frameType == 0x41 && !p.uni {
+			// WebTransport: the session ID is followed by the data of the application
+			p.log.Debugf("HTTP/3 frame %s on stream %d: WebTransport stream of session %d", p.direction, p.streamID, length)
+			p.done = true
+			continue
+		}
+		p.frameType, p.length, p.remaining = frameType, length, length
+		p.payload = p.payload[:0]
+		// the payload of the frames other than DATA and HEADERS is decoded
+		p.decode = frameType != 0x0 && frameType != 0x1 && length <= maxLoggedFramePayload
+		if !p.decode || length == 0 {
+			p.logFrame()
+		}
+	}
+}
+
+func (p *h3FrameParser) logFrame() {
+	if !p.log.DebugEnabled() {
+		return
+	}
+	name, ok := h3FrameNames[p.frameType]
+	if !ok {
+		name = fmt.Sprintf("%#x", p.frameType)
+		if p.frameType >= 0x21 && (p.frameType-0x21)%0x1f == 0 {
+			name = fmt.Sprintf("reserved(%#x)", p.frameType)
+		}
+	}
+	var details string
+	if p.decode {
+		details = decodeH3Frame(p.frameType, p.payload)
+	}
+	p.log.Debugf("HTTP/3 frame %s on stream %d: %s (%d bytes)%s", p.direction, p.streamID, name, p.length, details)
+}
+
+// decodeH3Frame describes the payload of a control frame
+func decodeH3Frame(frameType uint64, payload []byte) string {
+	switch frameType {
+	case 0x4:
+		var settings []string
+		for len(payload) > 0 {
+			id, n, ok := varint(payload)
+			if !ok {
+				break
+			}
+			value, m, ok := varint(payload[n:])
+			if !ok {
+				break
+			}
+			payload = payload[n+m:]
+			name, known := h3SettingNames[id]
+			if !known {
+				name = fmt.Sprintf("%#x", id)
+			}
+			settings = append(settings, fmt.Sprintf("%s=%d", name, value))
+		}
+		if len(settings) > 0 {
+			return " " + strings.Join(settings, " ")
+		}
+	case 0x3, 0x7, 0xd:
+		if id, _, ok := varint(payload); ok {
+			return fmt.Sprintf(" id=%d", id)
+		}
+	case 0xf0700, 0xf0701:
+		if id, n, ok := varint(payload); ok {
+			return fmt.Sprintf(" element=%d priority=%q", id, payload[n:])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+var handshakeFailures = newCounterVec("quicgo_handshake_failures_total", "Failed QUIC handshakes by reason", "reason")
+
+// TLS alerts carried by the CRYPTO_ERROR transport error codes (RFC 8446 section 6)
+const (
+	alertBadCertificate         = 42
+	alertUnsupportedCertificate = 43
+	alertCertificateRevoked     = 44
+	alertCertificateExpired     = 45
+	alertCertificateUnknown     = 46
+	alertUnknownCA              = 48
+	alertCertificateRequired    = 116
+	alertNoApplicationProtocol  = 120
+)
+
+// handshakeState follows the handshake of one connection to explain why it failed
+type handshakeState struct {
+	mutex     sync.Mutex
+	remote    net.Addr
+	done      bool
+	validated bool // the client proved it owns its address by sending a Handshake packet
+	sent      logging.ByteCount
+	received  logging.ByteCount
+}
+
+// failureReason classifies the error closing a connection before the end of the handshake
+func (s *handshakeState) failureReason(err error) string {
+	var transportErr *quic.TransportError
+	var idleErr *quic.IdleTimeoutError
+	var handshakeErr *quic.HandshakeTimeoutError
+	switch {
+	case errors.As(err, &transportErr):
+		if !transportErr.ErrorCode.IsCryptoError() {
+			return "transport_error"
+		}
+		switch uint8(transportErr.ErrorCode - quic.TransportErrorCode(0x100)) {
+		case alertNoApplicationProtocol:
+			return "no_application_protocol"
+		case alertBadCertificate, alertUnsupportedCertificate, alertCertificateRevoked, alertCertificateExpired,
+			alertCertificateUnknown, alertUnknownCA, alertCertificateRequired:
+			return "certificate"
+		}
+		return "tls"
+	case errors.As(err, &idleErr), errors.As(err, &handshakeErr):
+		// until its address is validated, the server can't send more than 3 times what it received (RFC 9000 section 8)
+		if !s.validated && s.sent+1200 >= 3*s.received {
+			return "amplification_limit"
+		}
+		return "timeout"
+	}
+	return "other"
+}
+
+// newHandshakeTracer returns a tracer logging and counting the handshakes that fail
+func newHandshakeTracer() func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer {
+	return func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+		s := &handshakeState{}
+		l := loggerFrom(ctx)
+		return &logging.ConnectionTracer{
+			StartedConnection: func(local, remote net.Addr, srcConnID, destConnID logging.ConnectionID) {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				s.remote = remote
+			},
+			SentLongHeaderPacket: func(hdr *logging.ExtendedHeader, size logging.ByteCount, _ logging.ECN, _ *logging.AckFrame, _ []logging.Frame) {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				s.sent += size
+			},
+			ReceivedLongHeaderPacket: func(hdr *logging.ExtendedHeader, size logging.ByteCount, _ logging.ECN, _ []logging.Frame) {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				s.received += size
+				if logging.PacketTypeFromHeader(&hdr.Header) == logging.PacketTypeHandshake {
+					s.validated = true
+				}
+			},
+			DroppedEncryptionLevel: func(encLevel logging.EncryptionLevel) {
+				// the server drops the Handshake keys once the handshake is complete
+				if encLevel == logging.EncryptionHandshake {
+					s.mutex.Lock()
+					defer s.mutex.Unlock()
+					s.done = true
+				}
+			},
+			ClosedConnection: func(err error) {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				if s.done {
+					return
+				}
+				reason := s.failureReason(err)
+				handshakeFailures.inc(reason)
+				l.Warnf("Handshake with %s failed (%s): %v", s.remote, reason, err)
+			},
+		}
+	}
+}
+
+// newHandshakeTransportTracer returns a tracer of the transport counting the version mismatches,
+// which happen before any connection is created
+func newHandshakeTransportTracer() *logging.Tracer {
+	return &logging.Tracer{
+		SentVersionNegotiationPacket: func(remote net.Addr, _, _ logging.ArbitraryLenConnectionID, _ []logging.VersionNumber) {
+			handshakeFailures.inc("version_negotiation")
+			log.Warnf("Handshake with %s failed (version_negotiation): unsupported QUIC version", remote)
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+func TestHandshakeFailureReason(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		validated      bool
+		sent, received logging.ByteCount
+		reason         string
+	}{
+		{"transport error", &quic.TransportError{ErrorCode: quic.ProtocolViolation}, true, 0, 0, "transport_error"},
+		{"no application protocol", &quic.TransportError{ErrorCode: 0x100 + alertNoApplicationProtocol}, true, 0, 0, "no_application_protocol"},
+		{"unknown ca", &quic.TransportError{ErrorCode: 0x100 + alertUnknownCA}, true, 0, 0, "certificate"},
+		{"certificate required", &quic.TransportError{ErrorCode: 0x100 + alertCertificateRequired}, true, 0, 0, "certificate"},
+		{"handshake failure alert", &quic.TransportError{ErrorCode: 0x100 + 40}, true, 0, 0, "tls"},
+		{"wrapped", fmt.Errorf("handshake: %w", &quic.TransportError{ErrorCode: 0x100 + alertUnknownCA}), true, 0, 0, "certificate"},
+		{"idle timeout", &quic.IdleTimeoutError{}, true, 6000, 1200, "timeout"},
+		{"handshake timeout", &quic.HandshakeTimeoutError{}, false, 1200, 1200, "timeout"},
+		{"amplification limit", &quic.HandshakeTimeoutError{}, false, 2400, 1200, "amplification_limit"},
+		{"other", errors.New("closed"), false, 0, 0, "other"},
+	}
+	for _, test := range tests {
+		s := &handshakeState{validated: test.validated, sent: test.sent, received: test.received}
+		if reason := s.failureReason(test.err); reason != test.reason {
+			t.Errorf("%s: reason %q, expected %q", test.name, reason, test.reason)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// hqALPN is the ALPN of the HTTP/0.9 mapping used by the quic-interop-runner
+const hqALPN = "hq-interop"
+
+// hqResponseWriter discards the status and headers, HTTP/0.9 only carries the body
+type hqResponseWriter struct {
+	str    quic.Stream
+	header http.Header
+}
+
+func (w *hqResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *hqResponseWriter) WriteHeader(int) {}
+
+func (w *hqResponseWriter) Write(p []byte) (int, error) {
+	return w.str.Write(p)
+}
+
+// serveHQ serves HTTP/0.9 requests, one "GET /path" per bidirectional stream
+func serveHQ(ln http3.QUICEarlyListener, handler http.Handler) error {
+	for {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return err
+		}
+		go func() {
+			for {
+				str, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					log.Debugf("Accepting HTTP/0.9 stream failed: %v", err)
+					return
+				}
+				go handleHQStream(conn, str, handler)
+			}
+		}()
+	}
+}
+
+func handleHQStream(conn quic.Connection, str quic.Stream, handler http.Handler) {
+	defer str.Close()
+
+	reqBytes, err := io.ReadAll(io.LimitReader(str, 8192))
+	if err != nil {
+		log.Debugf("Reading HTTP/0.9 request failed: %v", err)
+		return
+	}
+	request := strings.TrimRight(string(reqBytes), "\r\n ")
+	path, ok := strings.CutPrefix(request, "GET ")
+	if !ok || !strings.HasPrefix(path, "/") {
+		str.CancelWrite(0)
+		return
+	}
+	u, err := url.ParseRequestURI(path)
+	if err != nil {
+		str.CancelWrite(0)
+		return
+	}
+	log.Infof("HTTP/0.9 GET %s", path)
+
+	r := &http.Request{
+		Method:     http.MethodGet,
+		Proto:      "HTTP/0.9",
+		URL:        u,
+		RequestURI: path,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		RemoteAddr: conn.RemoteAddr().String(),
+	}
+	handler.ServeHTTP(&hqResponseWriter{str: str, header: http.Header{}}, r.WithContext(str.Context()))
+}
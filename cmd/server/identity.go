@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// clientCertHeaderPrefix starts the headers carrying the client identity to the proxied origin
+const clientCertHeaderPrefix = "X-Client-Cert-"
+
+type clientIdentityKey struct{}
+
+// clientIdentity describes the verified certificate of a mTLS client
+type clientIdentity struct {
+	Subject     string   `json:"subject"`
+	Issuer      string   `json:"issuer"`
+	SANs        []string `json:"sans,omitempty"` // DNS names, emails, IPs and URIs
+	Fingerprint string   `json:"fingerprint"`    // hex SHA-256 of the DER certificate
+}
+
+func newClientIdentity(cert *x509.Certificate) *clientIdentity {
+	sum := sha256.Sum256(cert.Raw)
+	id := &clientIdentity{
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+	id.SANs = append(id.SANs, cert.DNSNames...)
+	id.SANs = append(id.SANs, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		id.SANs = append(id.SANs, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		id.SANs = append(id.SANs, uri.String())
+	}
+	return id
+}
+
+// clientIdentityFromContext returns the identity of the client of a request, nil without a verified certificate
+func clientIdentityFromContext(ctx context.Context) *clientIdentity {
+	id, _ := ctx.Value(clientIdentityKey{}).(*clientIdentity)
+	return id
+}
+
+// withClientIdentity adds to the request context the identity of the clients with a verified certificate
+func withClientIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			id := newClientIdentity(r.TLS.VerifiedChains[0][0])
+			r = r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deleteClientCertHeaders removes the X-Client-Cert-* headers sent by a client, which must not be able
+// to choose its identity, whether the proxy sets them or not
+func deleteClientCertHeaders(header http.Header) {
+	for name := range header {
+		if strings.HasPrefix(name, clientCertHeaderPrefix) {
+			header.Del(name)
+		}
+	}
+}
+
+// setClientCertHeaders sets the X-Client-Cert-* headers of r, deleted beforehand, to the identity of its client
+func setClientCertHeaders(r *http.Request) {
+	id := clientIdentityFromContext(r.Context())
+	if id == nil {
+		return
+	}
+	r.Header.Set(clientCertHeaderPrefix+"Subject", id.Subject)
+	r.Header.Set(clientCertHeaderPrefix+"Issuer", id.Issuer)
+	if len(id.SANs) > 0 {
+		r.Header.Set(clientCertHeaderPrefix+"SAN", strings.Join(id.SANs, ","))
+	}
+	r.Header.Set(clientCertHeaderPrefix+"Fingerprint", id.Fingerprint)
+}
+
+// whoamiHandler returns the identity of the client certificate in JSON
+func whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	id := clientIdentityFromContext(r.Context())
+	if id == nil {
+		http.Error(w, "no verified client certificate", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(id)
+}
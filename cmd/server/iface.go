@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// interfaceBinds expands a bind on a network interface name, like eth0:6121, into a bind on each
+// address of the interface, restricted to bc.family (4 or 6) when set. The link-local addresses are
+// left out. The addresses are resolved once at startup, the binds on other hosts are returned as is.
+func interfaceBinds(bc bindConfig) ([]bindConfig, error) {
+	host, port, err := net.SplitHostPort(bc.addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return bindsOfFamily(bc)
+	}
+	ifi, err := net.InterfaceByName(host)
+	if err != nil {
+		// a host name
+		return bindsOfFamily(bc)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the addresses of the interface %s: %w", ifi.Name, err)
+	}
+	var bcs []bindConfig
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() || !matchesFamily(ipNet.IP, bc.family) {
+			continue
+		}
+		ifBind := bc
+		ifBind.addr = net.JoinHostPort(ipNet.IP.String(), port)
+		bcs = append(bcs, ifBind)
+	}
+	if len(bcs) == 0 {
+		return nil, fmt.Errorf("no address to bind on the interface %s", ifi.Name)
+	}
+	if len(bcs) > 1 && len(bc.tcpListen) > 0 {
+		return nil, fmt.Errorf("the tcp-listen option of %s would be shared by its %d addresses", bc.addr, len(bcs))
+	}
+	return bcs, nil
+}
+
+// bindsOfFamily checks the family option of a bind which is not on an interface
+func bindsOfFamily(bc bindConfig) ([]bindConfig, error) {
+	if bc.family != 0 {
+		return nil, fmt.Errorf("the family option of bind %s requires an interface name", bc.addr)
+	}
+	return []bindConfig{bc}, nil
+}
+
+func matchesFamily(ip net.IP, family int) bool {
+	switch family {
+	case 4:
+		return ip.To4() != nil
+	case 6:
+		return ip.To4() == nil
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// demoEndpoint describes one endpoint on the generated index page
+type demoEndpoint struct {
+	Path        string
+	Example     string // clickable example, empty for the endpoints not usable from a browser
+	Description string
+}
+
+var demoEndpoints = []demoEndpoint{
+	{"/N", "/1024", "N bytes of pseudo-random data, up to 1 GB"},
+	{"/demo/tile?size=S", "/demo/tile", "Small 40x40 PNG image, or a generated one of SxS pixels"},
+	{"/demo/tiles?count=N&size=S", "/demo/tiles", "Page loading N tiles (200) of SxS pixels, to watch multiplexing at work"},
+	{"/demo/checksum", "", "POST or PUT a body, returns its SHA-256 and CRC32 without storing it"},
+	{"/demo/echo", "/demo/echo", "Echo of messages, over WebSockets when the page is not loaded with HTTP/3 (see -tcp)"},
+	{"/demo/capsules", "", "Extended CONNECT (:protocol capsule-echo) sending the DATAGRAM capsules back, see quicgo-client -capsules"},
+	{"/demo/datagram?loss=P", "", "Echo of the HTTP datagrams sent along the request until its body ends, P percent dropped on purpose, see quicgo-client -datagrams"},
+	{"/demo/chat", "/demo/chat", "Chat room, over WebSockets when the page is not loaded with HTTP/3 (see -tcp)"},
+	{"/echo?headers=H1,H2", "", "Streams the request body back, with the method, headers and trailers in X-Echo-* headers and trailers"},
+	{"/ping", "/ping", "Timestamps for the RTT measurement of quicgo-client -ping"},
+	{"/whoami", "/whoami", "Identity of the client certificate, when mTLS is enabled"},
+	{"/bench/upload", "", "POST or PUT a body, reports how fast it was received"},
+	{"/bench/download?duration=D", "/bench/download?duration=2s", "Streams data for the duration D"},
+	{"/bench/multistream?session=ID&streams=N&stream=I&size=S", "", "One stream of the benchmark run by quicgo-client -multistream"},
+	{"/bench/multistream/report?session=ID", "", "Report of a multistream benchmark session"},
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><title>quicgo example server</title></head>
+<body>
+<h1>quicgo example server {{.Version}}</h1>
+<table>
+<tr><th>Endpoint</th><th>Description</th></tr>
+{{range .Endpoints}}<tr><td>{{if .Example}}<a href="{{.Example}}">{{.Path}}</a>{{else}}{{.Path}}{{end}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+// indexHandler serves the page listing the demo and benchmark endpoints, used when there is no www directory
+func indexHandler(conf handlerConfig) http.HandlerFunc {
+	endpoints := demoEndpoints
+	if len(conf.dav) > 0 {
+		endpoints = append(endpoints[:len(endpoints):len(endpoints)], demoEndpoint{davPrefix + "/", "", "WebDAV share, requires the -auth credentials"})
+	}
+	for _, mount := range conf.mounts {
+		if prefix, dir, err := parseMount(mount); err == nil {
+			endpoints = append(endpoints[:len(endpoints):len(endpoints)], demoEndpoint{prefix + "/", prefix + "/", "Files of " + dir})
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		indexTemplate.Execute(w, struct {
+			Version   string
+			Endpoints []demoEndpoint
+		}{VERSION, endpoints})
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+// interopTestcase describes how the server must behave for a TESTCASE of the quic-interop-runner
+type interopTestcase struct {
+	hq        bool // serve HTTP/0.9 (hq-interop) instead of HTTP/3
+	retry     bool
+	allow0RTT bool
+}
+
+// interopTestcases lists the server side testcases of the quic-interop-runner we support.
+// chacha20 needs no change: the runner client only offers this cipher suite.
+var interopTestcases = map[string]interopTestcase{
+	"versionnegotiation": {hq: true},
+	"handshake":          {hq: true},
+	"transfer":           {hq: true},
+	"retry":              {hq: true, retry: true},
+	"resumption":         {hq: true},
+	"zerortt":            {hq: true, allow0RTT: true},
+	"multiconnect":       {hq: true},
+	"chacha20":           {hq: true},
+	"http3":              {},
+}
+
+// interopUnsupportedExitCode is the exit code expected by the runner for unsupported testcases
+const interopUnsupportedExitCode = 127
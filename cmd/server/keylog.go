@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// connKeyLogs writes the TLS secrets of every QUIC connection to its own file, named after the
+// connection ID of its qlog, so that one capture can be decrypted without the secrets of the others
+type connKeyLogs struct {
+	dir   string
+	mutex sync.Mutex
+	// starting are the connection IDs of the connections waiting for their ClientHello, by client address
+	starting map[string]quic.ConnectionID
+}
+
+func newConnKeyLogs(dir string) *connKeyLogs {
+	return &connKeyLogs{dir: dir, starting: make(map[string]quic.ConnectionID)}
+}
+
+// tracer notes the connection ID of the connections, which quic-go creates before handling the ClientHello
+func (k *connKeyLogs) tracer() func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer {
+	return func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+		var remote string
+		return &logging.ConnectionTracer{
+			StartedConnection: func(_, remoteAddr net.Addr, _, _ logging.ConnectionID) {
+				remote = remoteAddr.String()
+				k.mutex.Lock()
+				k.starting[remote] = connID
+				k.mutex.Unlock()
+			},
+			ClosedConnection: func(error) {
+				k.mutex.Lock()
+				if id, ok := k.starting[remote]; ok && id == connID {
+					delete(k.starting, remote)
+				}
+				k.mutex.Unlock()
+			},
+		}
+	}
+}
+
+// configure returns a copy of tlsConf writing the secrets of each connection to its file, and to the
+// KeyLogWriter of tlsConf when set
+func (k *connKeyLogs) configure(tlsConf *tls.Config) *tls.Config {
+	conf := tlsConf.Clone()
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		var connConf *tls.Config
+		if tlsConf.GetConfigForClient != nil {
+			var err error
+			if connConf, err = tlsConf.GetConfigForClient(hello); err != nil {
+				return nil, err
+			}
+		}
+		// quic-go gives the client address with a fake net.Conn
+		remote := hello.Conn.RemoteAddr().String()
+		k.mutex.Lock()
+		connID, ok := k.starting[remote]
+		delete(k.starting, remote)
+		k.mutex.Unlock()
+		if !ok {
+			log.Warnf("No connection ID known for the handshake of %s, its secrets are not logged in a file of their own", remote)
+			return connConf, nil
+		}
+		if connConf == nil {
+			connConf = tlsConf
+		}
+		connConf = connConf.Clone()
+		connConf.GetConfigForClient = nil
+		w := &keyLogFile{path: filepath.Join(k.dir, fmt.Sprintf("server_%s.keys", connID))}
+		if connConf.KeyLogWriter != nil {
+			connConf.KeyLogWriter = io.MultiWriter(connConf.KeyLogWriter, w)
+		} else {
+			connConf.KeyLogWriter = w
+		}
+		return connConf, nil
+	}
+	return conf
+}
+
+// keyLogFile appends the secrets to the file at path, created on their first line
+type keyLogFile struct {
+	path string
+}
+
+func (f *keyLogFile) Write(p []byte) (int, error) {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Errorf("Unable to write the key log file %s: %v", f.path, err)
+		return 0, err
+	}
+	defer file.Close()
+	return file.Write(p)
+}
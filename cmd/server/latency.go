@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// latencyQuantiles are the quantiles exposed for every histogram
+var latencyQuantiles = []float64{0.5, 0.9, 0.99, 0.999, 1}
+
+// latencyVec is a set of HDR histograms of durations sharing a name, exposed as a Prometheus summary.
+// The values are recorded in microseconds, from 1µs to 1 hour with 3 significant digits.
+type latencyVec struct {
+	name   string
+	help   string
+	labels []string
+	mutex  sync.Mutex
+	hists  map[string]*hdrhistogram.Histogram // keyed by the label values joined with \x00
+	sums   map[string]time.Duration
+}
+
+func newLatencyVec(name, help string, labels ...string) *latencyVec {
+	l := &latencyVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		hists:  make(map[string]*hdrhistogram.Histogram),
+		sums:   make(map[string]time.Duration),
+	}
+	registeredMetrics = append(registeredMetrics, l)
+	return l
+}
+
+func (l *latencyVec) observe(d time.Duration, values ...string) {
+	key := strings.Join(values, "\x00")
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	h, ok := l.hists[key]
+	if !ok {
+		h = hdrhistogram.New(1, int64(time.Hour/time.Microsecond), 3)
+		l.hists[key] = h
+	}
+	// values out of the range are clamped instead of being dropped
+	h.RecordValue(min(max(d.Microseconds(), 1), h.HighestTrackableValue()))
+	l.sums[key] += d
+}
+
+func (l *latencyVec) metricName() string {
+	return l.name
+}
+
+func (l *latencyVec) writeMetric(w io.Writer) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", l.name, l.help, l.name)
+	keys := make([]string, 0, len(l.hists))
+	for k := range l.hists {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var pairs []string
+		for i, v := range strings.Split(k, "\x00") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", l.labels[i], v))
+		}
+		labels := strings.Join(pairs, ",")
+		h := l.hists[k]
+		for _, q := range latencyQuantiles {
+			secs := float64(h.ValueAtQuantile(q*100)) / 1e6
+			fmt.Fprintf(w, "%s{%s,quantile=\"%g\"} %g\n", l.name, labels, q, secs)
+		}
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", l.name, labels, l.sums[k].Seconds())
+		fmt.Fprintf(w, "%s_count{%s} %d\n", l.name, labels, h.TotalCount())
+	}
+}
+
+var requestLatency = newLatencyVec("quicgo_request_duration_seconds",
+	"Time to the response headers (phase=\"handler\") and to the last byte of the body (phase=\"last_byte\") by route",
+	"route", "phase")
+
+// latencyRecorder notes when the handler writes the response headers
+type latencyRecorder struct {
+	http.ResponseWriter
+	headersAt time.Time
+}
+
+func (r *latencyRecorder) WriteHeader(status int) {
+	if r.headersAt.IsZero() {
+		r.headersAt = time.Now()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *latencyRecorder) Write(p []byte) (int, error) {
+	if r.headersAt.IsZero() {
+		r.headersAt = time.Now()
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *latencyRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *latencyRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// latencyRouteKey is the context key of the route recorded by recordLatency, a *string
+type latencyRouteKey struct{}
+
+// recordLatency measures the requests served by next in the histogram of the route they matched in mux,
+// the one of the rewritten request when the chain of next routes it with latencyRoute
+func recordLatency(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		r = r.WithContext(context.WithValue(r.Context(), latencyRouteKey{}, &route))
+		start := time.Now()
+		rec := &latencyRecorder{ResponseWriter: w}
+		next.ServeHTTP(keepHijacker(w, rec), r)
+		end := time.Now()
+		if rec.headersAt.IsZero() {
+			// nothing written, the headers are sent when the handler returns
+			rec.headersAt = end
+		}
+		if route == "" {
+			route = "none"
+		}
+		requestLatency.observe(rec.headersAt.Sub(start), route, "handler")
+		requestLatency.observe(end.Sub(start), route, "last_byte")
+	})
+}
+
+// latencyRoute serves the requests with mux, recording the route they match for recordLatency once
+// rewritten by the -url-rules
+func latencyRoute(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(latencyRouteKey{}).(*string); ok {
+			_, *route = mux.Handler(r)
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
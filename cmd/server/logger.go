@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/logging"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logger is what the server logs with, whatever the backend selected with -log-backend.
+// The fields added by WithField, such as the conn_id of the per-connection loggers, are
+// written apart from the message by the structured backends.
+type logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+	Fatalf(format string, args ...any)
+	Info(args ...any)
+	Error(args ...any)
+	Fatal(args ...any)
+	WithField(key string, value any) logger
+	// DebugEnabled tells whether the debug messages are written
+	DebugEnabled() bool
+}
+
+// log is the root logger, a logrus one writing to stderr until the flags are parsed
+var log logger = newLogrusLogger(os.Stderr)
+
+// logBackends create the root logger writing to w, with the function switching its debug messages on and off
+var logBackends = map[string]func(w io.Writer) (logger, func(debug bool)){
+	"logrus": func(w io.Writer) (logger, func(bool)) {
+		l := newLogrusLogger(w)
+		return l, func(debug bool) {
+			l.Logger.SetLevel(logrus.InfoLevel)
+			if debug {
+				l.Logger.SetLevel(logrus.DebugLevel)
+			}
+		}
+	},
+	"slog": func(w io.Writer) (logger, func(bool)) {
+		level := new(slog.LevelVar)
+		l := slogLogger{slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))}
+		return l, func(debug bool) {
+			level.Set(slog.LevelInfo)
+			if debug {
+				level.Set(slog.LevelDebug)
+			}
+		}
+	},
+	"zap": func(w io.Writer) (logger, func(bool)) {
+		level := zap.NewAtomicLevel()
+		conf := zap.NewProductionEncoderConfig()
+		conf.EncodeTime = zapcore.ISO8601TimeEncoder
+		l := zapLogger{zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(conf), zapcore.AddSync(w), level)).Sugar()}
+		return l, func(debug bool) {
+			level.SetLevel(zapcore.InfoLevel)
+			if debug {
+				level.SetLevel(zapcore.DebugLevel)
+			}
+		}
+	},
+}
+
+// newLogger creates the root logger of the backend name
+func newLogger(name string, w io.Writer) (logger, func(debug bool), error) {
+	backend, ok := logBackends[name]
+	if !ok {
+		names := make([]string, 0, len(logBackends))
+		for n := range logBackends {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, nil, fmt.Errorf("unknown log backend %s, expected one of %s", name, strings.Join(names, ","))
+	}
+	l, setDebug := backend(w)
+	return l, setDebug, nil
+}
+
+// logrusLogger writes the messages in the text format of logrus, the fields after them
+type logrusLogger struct {
+	*logrus.Entry
+}
+
+func newLogrusLogger(w io.Writer) logrusLogger {
+	l := logrus.New()
+	l.SetFormatter(&logrus.TextFormatter{})
+	l.SetOutput(w)
+	return logrusLogger{logrus.NewEntry(l)}
+}
+
+func (l logrusLogger) WithField(key string, value any) logger {
+	return logrusLogger{l.Entry.WithField(key, value)}
+}
+
+func (l logrusLogger) DebugEnabled() bool {
+	return l.Logger.IsLevelEnabled(logrus.DebugLevel)
+}
+
+// slogLogger writes JSON lines with the log/slog package
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (l slogLogger) log(level slog.Level, msg string) {
+	l.l.Log(context.Background(), level, msg)
+}
+
+func (l slogLogger) Debugf(format string, args ...any) {
+	if l.DebugEnabled() {
+		l.log(slog.LevelDebug, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l slogLogger) Infof(format string, args ...any) {
+	l.log(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l slogLogger) Warnf(format string, args ...any) {
+	l.log(slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (l slogLogger) Errorf(format string, args ...any) {
+	l.log(slog.LevelError, fmt.Sprintf(format, args...))
+}
+
+func (l slogLogger) Fatalf(format string, args ...any) {
+	l.log(slog.LevelError, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func (l slogLogger) Info(args ...any) {
+	l.log(slog.LevelInfo, fmt.Sprint(args...))
+}
+
+func (l slogLogger) Error(args ...any) {
+	l.log(slog.LevelError, fmt.Sprint(args...))
+}
+
+func (l slogLogger) Fatal(args ...any) {
+	l.log(slog.LevelError, fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+func (l slogLogger) WithField(key string, value any) logger {
+	return slogLogger{l.l.With(key, value)}
+}
+
+func (l slogLogger) DebugEnabled() bool {
+	return l.l.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// zapLogger writes JSON lines with zap
+type zapLogger struct {
+	*zap.SugaredLogger
+}
+
+func (l zapLogger) WithField(key string, value any) logger {
+	return zapLogger{l.SugaredLogger.With(key, value)}
+}
+
+func (l zapLogger) DebugEnabled() bool {
+	return l.Level().Enabled(zapcore.DebugLevel)
+}
+
+type loggerKey struct{}
+
+// withLogger returns a copy of ctx carrying l, such as the logger of a connection
+func withLogger(ctx context.Context, l logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFrom returns the logger carried by ctx, the root logger when it has none
+func loggerFrom(ctx context.Context) logger {
+	if l, ok := ctx.Value(loggerKey{}).(logger); ok {
+		return l
+	}
+	return log
+}
+
+// connLoggers are the loggers of the QUIC connections by tracing ID, to log the requests with the fields of their connection
+var connLoggers = struct {
+	sync.Mutex
+	byTracingID map[uint64]logger
+}{byTracingID: make(map[uint64]logger)}
+
+// newConnLoggerTracer registers the logger given by newMultiplexedTracer to the connections while they are open
+func newConnLoggerTracer() func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer {
+	return func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+		tracingID, _ := ctx.Value(quic.ConnectionTracingKey).(uint64)
+		connLoggers.Lock()
+		connLoggers.byTracingID[tracingID] = loggerFrom(ctx)
+		connLoggers.Unlock()
+		return &logging.ConnectionTracer{
+			Close: func() {
+				connLoggers.Lock()
+				delete(connLoggers.byTracingID, tracingID)
+				connLoggers.Unlock()
+			},
+		}
+	}
+}
+
+// connLogger returns the logger of conn, the root logger once it is closed
+func connLogger(conn quic.Connection) logger {
+	tracingID, _ := conn.Context().Value(quic.ConnectionTracingKey).(uint64)
+	connLoggers.Lock()
+	defer connLoggers.Unlock()
+	if l, ok := connLoggers.byTracingID[tracingID]; ok {
+		return l
+	}
+	return log
+}
+
+// withConnLogger gives the handlers the logger of the QUIC connection of their request in its context,
+// the root logger over the TCP fallback
+func withConnLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := w.(http3.Hijacker); ok {
+			if conn, ok := h.StreamCreator().(quic.Connection); ok {
+				r = r.WithContext(withLogger(r.Context(), connLogger(conn)))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,22 +3,30 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	_ "net/http/pprof"
 
 	"github.com/quic-go/quic-go"
-	"github.com/quic-go/quic-go/http3"
 	"github.com/quic-go/quic-go/logging"
 	"github.com/quic-go/quic-go/qlog"
-	log "github.com/sirupsen/logrus"
 )
 
 type binds []string
@@ -28,10 +36,37 @@ func (b binds) String() string {
 }
 
 func (b *binds) Set(v string) error {
-	*b = strings.Split(v, ",")
+	*b = append(*b, splitBinds(v)...)
 	return nil
 }
 
+func (b *binds) replace(values []string) {
+	*b = nil
+	for _, v := range values {
+		*b = append(*b, splitBinds(v)...)
+	}
+}
+
+// splitBinds splits a -bind value into its comma separated entries. In the options of an entry, a comma
+// separates the items of a list option (e.g. alpn=hq-interop,quicgo-echo) unless it is followed by another address.
+func splitBinds(v string) []string {
+	var entries []string
+	for _, part := range strings.Split(v, ",") {
+		if n := len(entries); n > 0 && strings.Contains(entries[n-1], "?") && !startsBind(part) {
+			entries[n-1] += "," + part
+			continue
+		}
+		entries = append(entries, part)
+	}
+	return entries
+}
+
+// startsBind returns whether s starts with a host:port or interface:port address, rather than an option item
+func startsBind(s string) bool {
+	addr, _, _ := strings.Cut(s, "?")
+	return strings.Contains(addr, ":") && !strings.Contains(addr, "=")
+}
+
 type bufferedWriteCloser struct {
 	*bufio.Writer
 	io.Closer
@@ -60,54 +95,153 @@ type Size interface {
 // See https://en.wikipedia.org/wiki/Lehmer_random_number_generator
 func generatePRData(l int) []byte {
 	res := make([]byte, l)
-	seed := uint64(1)
-	for i := 0; i < l; i++ {
-		seed = seed * 48271 % 2147483647
-		res[i] = byte(seed)
-	}
+	fillPRData(res, 1)
 	return res
 }
 
-func setupHandler(www string) http.Handler {
+// handlerConfig groups the options used to build the HTTP handler
+type handlerConfig struct {
+	www    string
+	mounts []string // "/prefix=/path/to/dir" directories served under a prefix
+	dav    string
+	auth   string // "user:password" credentials for the protected endpoints
+
+	proxy      *url.URL // origin of the reverse proxy mode, nil when disabled
+	proxyCache int64    // size in bytes of the proxy cache, 0 when disabled
+	// directory keeping the proxy cache across the restarts, empty to keep it in memory
+	proxyCacheDir string
+	// forward the identity of the mTLS clients to the origin in X-Client-Cert-* headers
+	proxyClientCert bool
+	mirror          *url.URL // shadow backend receiving a copy of mirrorPercent % of the proxied requests
+	mirrorPercent   float64
+
+	fileChunkSize int // size of the chunks the static files are sent in, 0 for the io.Copy default
+	// size limit of the request bodies of the upload, echo and checksum endpoints, 0 for no limit
+	maxRequestBody int64
+
+	errorPages *errorPages // renders the error responses, nil to keep the bodies of the handlers
+	geoip      *geoIP      // tags the access logs and filters the clients by country, nil when disabled
+
+	plugins []string // paths of the Go plugins providing more handlers
+	cgi     []string // "/pattern=/path/to/program" routes served by CGI programs
+
+	urlRules urlRules // rewrites and redirects applied before the routing, nil for none
+
+	middlewares []string // names of the middlewares wrapping the handlers, outermost first
+	middleware  middlewareSettings
+}
+
+func setupHandler(conf handlerConfig) (http.Handler, error) {
 	mux := http.NewServeMux()
 
-	if len(www) > 0 {
-		mux.Handle("/", http.FileServer(http.Dir(www)))
+	if conf.proxy != nil {
+		var proxy http.Handler = newProxyHandler(conf.proxy, conf.proxyClientCert)
+		if conf.proxyCache > 0 {
+			cache := newResponseCache(conf.proxyCache)
+			if len(conf.proxyCacheDir) > 0 {
+				var err error
+				if cache, err = newDiskResponseCache(conf.proxyCacheDir, conf.proxyCache); err != nil {
+					return nil, fmt.Errorf("unable to open the proxy cache in %s: %w", conf.proxyCacheDir, err)
+				}
+			}
+			proxy = cache.handler(proxy)
+		}
+		if conf.mirror != nil && conf.mirrorPercent > 0 {
+			proxy = newMirror(conf.mirror, conf.mirrorPercent).handler(proxy)
+		}
+		mux.Handle("/", proxy)
+	} else if len(conf.www) > 0 {
+		var files http.Handler = http.FileServer(http.Dir(conf.www))
+		if conf.fileChunkSize > 0 {
+			files = newFileStreamer(conf.fileChunkSize).handler(files)
+		}
+		mux.Handle("/", files)
+		mux.HandleFunc("/api/files", filesHandler(conf.www))
 	} else {
+		index := indexHandler(conf)
 		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			fmt.Printf("%#v\n", r)
+			if r.URL.Path == "/" {
+				index(w, r)
+				return
+			}
 			const maxSize = 1 << 30 // 1 GB
 			num, err := strconv.ParseInt(strings.ReplaceAll(r.RequestURI, "/", ""), 10, 64)
 			if err != nil || num <= 0 || num > maxSize {
 				w.WriteHeader(400)
 				return
 			}
-			w.Write(generatePRData(int(num)))
+			w.Header().Set("Content-Length", strconv.FormatInt(num, 10))
+			prData.writeTo(w, num)
 		})
 	}
 
-	mux.HandleFunc("/demo/tile", func(w http.ResponseWriter, r *http.Request) {
-		// Small 40x40 png
-		w.Write([]byte{
-			0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d,
-			0x49, 0x48, 0x44, 0x52, 0x00, 0x00, 0x00, 0x28, 0x00, 0x00, 0x00, 0x28,
-			0x01, 0x03, 0x00, 0x00, 0x00, 0xb6, 0x30, 0x2a, 0x2e, 0x00, 0x00, 0x00,
-			0x03, 0x50, 0x4c, 0x54, 0x45, 0x5a, 0xc3, 0x5a, 0xad, 0x38, 0xaa, 0xdb,
-			0x00, 0x00, 0x00, 0x0b, 0x49, 0x44, 0x41, 0x54, 0x78, 0x01, 0x63, 0x18,
-			0x61, 0x00, 0x00, 0x00, 0xf0, 0x00, 0x01, 0xe2, 0xb8, 0x75, 0x22, 0x00,
-			0x00, 0x00, 0x00, 0x49, 0x45, 0x4e, 0x44, 0xae, 0x42, 0x60, 0x82,
-		})
-	})
+	// the demo endpoints honor the X-Inject-* fault headers
+	demo := faultMux{mux}
+	demo.HandleFunc("/demo/tile", tileHandler)
+	demo.HandleFunc("/demo/tiles", tilesHandler)
 
-	mux.HandleFunc("/demo/tiles", func(w http.ResponseWriter, r *http.Request) {
-		io.WriteString(w, "<html><head><style>img{width:40px;height:40px;}</style></head><body>")
-		for i := 0; i < 200; i++ {
-			fmt.Fprintf(w, `<img src="/demo/tile?cachebust=%d">`, i)
-		}
-		io.WriteString(w, "</body></html>")
-	})
+	demo.HandleFunc("/demo/checksum", limitBody(conf.maxRequestBody, checksumHandler))
+	demo.HandleFunc("/demo/echo", echoPageHandler)
+	demo.HandleFunc("/demo/echo/message", limitBody(conf.maxRequestBody, echoMessageHandler))
+	demo.Handle("/ws/echo", echoWebsocketHandler)
+	demo.HandleFunc("/demo/capsules", capsuleHandler)
+	demo.HandleFunc("/demo/datagram", datagramHandler)
+	chat := newChatRoom()
+	demo.HandleFunc("/demo/chat", chatPageHandler)
+	demo.HandleFunc("/demo/chat/events", chat.eventsHandler)
+	demo.HandleFunc("/demo/chat/send", chat.sendHandler)
+	demo.Handle("/ws/chat", chat.websocketHandler())
+
+	demo.HandleFunc("/echo", limitBody(conf.maxRequestBody, echoHandler))
+	demo.HandleFunc("/ping", pingHandler)
+	demo.HandleFunc("/whoami", whoamiHandler)
+	demo.HandleFunc("/bench/upload", limitBody(conf.maxRequestBody, benchUploadHandler))
+	demo.HandleFunc("/bench/download", benchDownloadHandler)
+	multistream := newMultistreamBench()
+	demo.HandleFunc("/bench/multistream", multistream.streamHandler)
+	demo.HandleFunc("/bench/multistream/report", multistream.reportHandler)
+
+	if len(conf.dav) > 0 {
+		dav := basicAuth(conf.auth, newDavHandler(conf.dav))
+		mux.Handle(davPrefix, dav)
+		mux.Handle(davPrefix+"/", dav)
+	}
 
-	return mux
+	if err := registerMounts(mux, conf); err != nil {
+		return nil, err
+	}
+	if err := registerExternalHandlers(mux, conf); err != nil {
+		return nil, err
+	}
+	var handler http.Handler = mux
+	if len(conf.urlRules) > 0 {
+		handler = conf.urlRules.handler(latencyRoute(mux))
+	}
+	if conf.geoip != nil {
+		handler = conf.geoip.handler(handler)
+	}
+	handler, err := chainMiddlewares(conf, handler)
+	if err != nil {
+		return nil, err
+	}
+	if conf.errorPages != nil {
+		handler = conf.errorPages.handler(handler)
+	}
+	return recordLatency(mux, withClientIdentity(handler)), nil
+}
+
+// newQlogTracer returns a tracer writing a qlog file per connection in dir, with only the events
+// of the given categories (all of them when nil)
+func newQlogTracer(dir string, events map[string]bool) func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer {
+	return func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+		filename := filepath.Join(dir, fmt.Sprintf("server_%s.qlog", connID))
+		f, err := os.Create(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("Creating qlog file %s", filename)
+		return filterQlogEvents(qlog.NewConnectionTracer(NewBufferedWriteCloser(bufio.NewWriter(f), f), p, connID), events)
+	}
 }
 
 var (
@@ -116,25 +250,139 @@ var (
 
 func main() {
 	verbose := flag.Bool("v", false, "verbose")
+	configPath := flag.String("config", "", "File of \"flag-name = value\" lines setting the flags, with [bind <address>] sections of -bind options, watched to apply the changes of -v, -rate-limit, -rate-burst, -geoip-allow and -geoip-deny at runtime")
 	bs := binds{}
-	flag.Var(&bs, "bind", "bind to")
+	flag.Var(&bs, "bind", "bind to, comma separated or repeated, each entry can carry its own options (e.g. 0.0.0.0:6121?www=/srv/a&alpn=hq-interop,quicgo-echo), also set by the [bind <address>] sections of -config, an interface name binds its addresses (e.g. eth0:6121?family=4)")
 	www := flag.String("www", "", "www data")
+	mounts := repeated{}
+	flag.Var(&mounts, "mount", "/prefix=/path/to/dir directory served under a URL prefix, next to -www or the demo endpoints, can be repeated")
 	tcp := flag.Bool("tcp", false, "also listen on TCP")
+	alpns := flag.String("alpn", "", "Comma separated raw QUIC protocols served next to HTTP/3 on the same socket, by ALPN (hq-interop, quicgo-echo, quicgo-socks5 which requires -auth)")
+	socksAllow := flag.String("socks-allow", "", "Comma separated networks (e.g. 10.1.0.0/16) the quicgo-socks5 gateway may connect to although they are loopback, link-local or private")
+	tcpListen := flag.String("tcp-listen", "", "Address of the TCP fallback listener, host:port or unix:/path/to.sock (defaults to the bind address)")
+	headerTimeout := flag.Duration("header-timeout", 10*time.Second, "Time given to the clients to send the headers of a request, the HTTP/3 streams are reset with H3_REQUEST_REJECTED past it (0 for no limit)")
+	streamIdleTimeout := flag.Duration("stream-idle-timeout", 0, "Reset the HTTP/3 request streams without data sent or received for this long with H3_REQUEST_CANCELLED, mind the long polls like /demo/chat/events (0 for no limit)")
+	httpsRedirect := flag.Bool("https-redirect", false, "Redirect the plain HTTP requests sent to the TCP fallback listener to HTTPS on the same port")
+	urlRulesFile := flag.String("url-rules", "", "File of \"rewrite <regexp> <replacement>\" and \"redirect <301|302|307|308> <regexp> <target>\" lines applied in order to the request paths")
 	enableQlog := flag.Bool("qlog", false, "output a qlog (in the same directory)")
+	qlogDir := flag.String("qlog-dir", ".", "Directory of the qlog files")
+	keyLogFile := flag.String("keylog", "", "File the TLS secrets of all the connections are written to")
+	keyLogPerConn := flag.Bool("keylog-per-conn", false, "Write the TLS secrets of each QUIC connection to its own file in -qlog-dir, named after the connection ID like its qlog")
+	h3FrameLog := flag.Bool("h3-frame-log", false, "Log the HTTP/3 frames sent and received with their stream ID and size, at debug level (with -v)")
+	qlogEvents := flag.String("qlog-events", "", "Comma separated qlog event categories to record among transport,security,recovery (defaults to all)")
+	retry := flag.Bool("retry", false, "Validate the address of every client with a Retry packet")
+	allow0RTT := flag.Bool("0rtt", false, "Accept 0-RTT connection attempts")
+	earlyDataRoutes := flag.String("0rtt-unsafe-routes", "", "Comma separated path prefixes accepting POST, PUT, DELETE... in 0-RTT, these requests get 425 Too Early elsewhere as they could be replayed")
+	tokenMaxAge := flag.Duration("token-max-age", 0, "Lifetime of the address validation tokens given to the clients for their next connections (defaults to 24h)")
+	retryTokenMaxAge := flag.Duration("retry-token-max-age", 0, "Lifetime of the Retry tokens, also sets the handshake idle timeout to half of it (defaults to 10s)")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "Handshakes allowed per client IP in -conns-per-ip-window, the next ones are refused (0 for no limit)")
+	connsPerIPWindow := flag.Duration("conns-per-ip-window", time.Minute, "Window in which -max-conns-per-ip handshakes are allowed, the count of each IP decays over it")
+	disableECN := flag.Bool("disable-ecn", false, "Disable ECN marking and validation on the UDP sockets")
+	disableGSO := flag.Bool("disable-gso", false, "Disable the UDP segmentation offload (GSO), broken in some virtualized environments")
+	disablePMTUD := flag.Bool("disable-pmtud", false, "Disable the Path MTU Discovery, for paths with a small MTU")
 	certFile := flag.String("cert-file", "", "Path to the server cert file")
 	keyFile := flag.String("key-file", "", "Path to the key file")
+	certExpiryWarning := flag.Duration("cert-expiry-warning", 30*24*time.Hour, "Warn about the certificates expiring within this duration")
+	certCheckInterval := flag.Duration("cert-check-interval", 12*time.Hour, "Interval between two checks of the certificate expiry dates")
+	refuseExpired := flag.Bool("refuse-expired", false, "Refuse to start when a loaded certificate is expired")
+	clientCAFile := flag.String("client-ca", "", "Path to the CA certs file of the client certificates, enables mTLS")
+	clientAuthOptional := flag.Bool("client-auth-optional", false, "Accept the clients without a certificate when mTLS is enabled")
+	clientCRLFile := flag.String("client-crl", "", "Path to the CRL file (PEM or DER) of the client certificates")
+	clientOCSP := flag.String("client-ocsp", "", "Check the client certificates with OCSP: soft accepts them when the responder can't be reached, hard refuses them")
+	maxRequestBody := flag.Int64("max-request-body", 1024, "Size limit in MB of the request bodies of the upload, echo and checksum endpoints, larger ones get 413 (0 for no limit)")
+	fileChunkSize := flag.Int("file-chunk-size", 256<<10, "Size in bytes of the chunks the -www files are read and sent in, with the next one read ahead (0 for the 32KB copies of io.Copy)")
+	dav := flag.String("dav", "", "Directory shared with WebDAV on "+davPrefix+" (requires -auth)")
+	auth := flag.String("auth", "", "user:password credentials required by the protected endpoints")
+	proxy := flag.String("proxy", "", "Origin URL to reverse proxy requests to, instead of serving local content")
+	runUser := flag.String("user", "", "User to switch to once the sockets are bound")
+	runGroup := flag.String("group", "", "Group to switch to once the sockets are bound (defaults to the primary group of -user)")
+	pidFile := flag.String("pid-file", "", "Write the pid of the server to this file, removed on shutdown, or only emptied with -user when its directory is not writable by the user (e.g. /run, prefer /run/quicgo owned by the user)")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "Time left to the connections to close on a binary upgrade (SIGUSR2), their packets being forwarded by the new process, before they are closed")
+	stdoutFile := flag.String("stdout", "", "Redirect the standard output to this file")
+	stderrFile := flag.String("stderr", "", "Redirect the standard error (and the logs) to this file")
+	proxyClientCert := flag.Bool("proxy-client-cert-headers", false, "Forward the identity of the mTLS clients to the proxy origin in X-Client-Cert-* headers")
+	mirror := flag.String("proxy-mirror", "", "URL of a shadow backend receiving a copy of the proxied requests, its responses are ignored")
+	mirrorPercent := flag.Float64("proxy-mirror-percent", 100, "Percentage of the proxied requests mirrored with -proxy-mirror")
+	proxyCache := flag.Int64("proxy-cache", 0, "Size in MB of the cache of proxied responses, in memory or in -proxy-cache-dir (0 disables it)")
+	proxyCacheDir := flag.String("proxy-cache-dir", "", "Directory keeping the -proxy-cache on disk across the restarts, one per bind, instead of in memory")
+	resumption := flag.Bool("tls-resumption", true, "Let the clients resume their TLS sessions and use 0-RTT, a full handshake for every connection otherwise")
+	sessionCache := flag.Int("tls-session-cache", 0, "Keep the resumable TLS sessions on the server in an LRU cache of this many entries, the tickets only carrying their ID (0 for the stateless tickets holding the encrypted session)")
+	connStatsFile := flag.String("conn-stats-file", "", "Append the transport statistics of each closed connection (peer, version, bytes, loss, RTT, close reason) to this file")
+	connStatsFormat := flag.String("conn-stats-format", "csv", "Format of -conn-stats-file: csv, or sqlite for the connections table of a SQLite database")
+	maxMemory := flag.Int64("max-memory", 0, "Budget in MB of the connection receive windows and the PRData cache, the windows shrinking as the connections grow in number (0 for the quic-go defaults)")
+	errorPagesDir := flag.String("error-pages", "", "Directory of the error page templates (404.html, 4xx.html, error.html)")
+	errorTemplate := flag.String("error-template", "", "Inline template of the error pages without a file in -error-pages")
+	plugins := repeated{}
+	flag.Var(&plugins, "plugin", "Go plugin exporting \"func Handlers() map[string]http.Handler\" to add handlers, can be repeated")
+	cgiRoutes := repeated{}
+	flag.Var(&cgiRoutes, "cgi", "/pattern=/path/to/program route served by a CGI program, can be repeated")
+	middlewares := flag.String("middlewares", "", "Comma separated middlewares wrapping all the handlers, the first one sees the requests first (log, auth, cors, gzip, ratelimit, security)")
+	corsOrigin := flag.String("cors-origin", "*", "Origin allowed by the cors middleware")
+	rateLimit := flag.Float64("rate-limit", 10, "Requests per second allowed for each client IP by the ratelimit middleware")
+	rateBurst := flag.Int("rate-burst", 20, "Request burst allowed for each client IP by the ratelimit middleware")
+	// the demo pages have inline scripts and styles
+	csp := flag.String("csp", "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'", "Content-Security-Policy set by the security middleware (empty to leave it out)")
+	hstsMaxAge := flag.Duration("hsts-max-age", 365*24*time.Hour, "max-age of the Strict-Transport-Security header set by the security middleware (0 to leave it out)")
+	geoipDB := flag.String("geoip-db", "", "MaxMind country or city database tagging the access logs with the client country")
+	geoipASNDB := flag.String("geoip-asn-db", "", "MaxMind ASN database tagging the access logs with the client AS (requires -geoip-db)")
+	geoipAllow := flag.String("geoip-allow", "", "Comma separated country codes whose clients are the only ones allowed, -- for the addresses not in the database")
+	geoipDeny := flag.String("geoip-deny", "", "Comma separated country codes whose clients are denied, -- for the addresses not in the database")
+	logBackend := flag.String("log-backend", "logrus", "Logging backend among logrus (text), slog and zap (JSON lines)")
+	logSyslog := flag.String("log-syslog", "", "Also send the logs in the RFC 5424 format to the syslog at host:port (UDP), udp:host:port, tcp:host:port or unix:/dev/log")
+	logSyslogFacility := flag.String("log-syslog-facility", "daemon", "Syslog facility of the -log-syslog messages")
+	adminAddr := flag.String("admin", "", "Address of the plain HTTP listener of the admin endpoints such as /metrics (disabled when empty)")
 	flag.Parse()
+	if err := setFlagsFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	var config *configFile
+	if len(*configPath) > 0 {
+		var err error
+		if config, err = loadConfigFile(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(*stdoutFile) > 0 {
+		if err := redirectOutput(*stdoutFile, &os.Stdout); err != nil {
+			log.Fatalf("Unable to redirect stdout: %v", err)
+		}
+	}
+	if len(*stderrFile) > 0 {
+		if err := redirectOutput(*stderrFile, &os.Stderr); err != nil {
+			log.Fatalf("Unable to redirect stderr: %v", err)
+		}
+	}
 
 	// init log
-	logWriter := os.Stderr
-	log.SetFormatter(&log.TextFormatter{})
-	log.SetOutput(logWriter)
-	log.SetLevel(log.InfoLevel)
-	if *verbose {
-		log.SetLevel(log.DebugLevel)
+	rootLogger, setDebug, err := newLogger(*logBackend, os.Stderr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setLogLevel := func() error {
+		setDebug(*verbose)
+		return nil
 	}
+	setLogLevel()
+	onDemandQlogs.enabled = len(*adminAddr) > 0 || *verbose
+	if len(*logSyslog) > 0 {
+		syslog, err := newSyslogWriter(*logSyslog, *logSyslogFacility)
+		if err != nil {
+			log.Fatalf("Unable to log to the syslog %s: %v", *logSyslog, err)
+		}
+		rootLogger = syslogLogger{logger: rootLogger, syslog: syslog}
+	}
+	log = rootLogger
 	log.Info("Starting quicgo example server - version " + VERSION)
 
+	if *disableECN {
+		// read by quic-go when it sets up the UDP sockets
+		os.Setenv("QUIC_GO_DISABLE_ECN", "true")
+	}
+	if *disableGSO {
+		os.Setenv("QUIC_GO_DISABLE_GSO", "true")
+	}
+
 	if len(bs) == 0 {
 		bs = binds{"localhost:6121"}
 	}
@@ -147,43 +395,385 @@ func main() {
 		log.Fatalf("Key file %s not exit", *keyFile)
 	}
 
-	handler := setupHandler(*www)
-	quicConf := &quic.Config{}
-	if *enableQlog {
-		quicConf.Tracer = func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
-			filename := fmt.Sprintf("server_%s.qlog", connID)
+	events, err := parseQlogEvents(*qlogEvents)
+	if err != nil {
+		log.Fatal(err)
+	}
+	middlewareNames, err := parseMiddlewares(*middlewares)
+	if err != nil {
+		log.Fatal(err)
+	}
+	alpnList, err := parseALPNs(*alpns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	socksAllowed, err := parseSOCKSAllow(*socksAllow)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *connsPerIPWindow <= 0 {
+		log.Fatal("-conns-per-ip-window must be positive")
+	}
+	if *allow0RTT && !*resumption {
+		log.Fatal("-0rtt needs -tls-resumption")
+	}
+	if *headerTimeout < 0 || *streamIdleTimeout < 0 {
+		log.Fatal("-header-timeout and -stream-idle-timeout must not be negative")
+	}
+	if *sessionCache < 0 {
+		log.Fatal("-tls-session-cache must not be negative")
+	}
+	if *maxRequestBody < 0 {
+		log.Fatal("-max-request-body must not be negative")
+	}
+	if *maxMemory < 0 {
+		log.Fatal("-max-memory must not be negative")
+	}
+	if len(*connStatsFile) > 0 {
+		if connStatsExport, err = newConnExport(*connStatsFile, *connStatsFormat); err != nil {
+			log.Fatalf("Unable to open -conn-stats-file: %v", err)
+		}
+		defer connStatsExport.close()
+	}
+	if *maxMemory > 0 {
+		memBudget = newMemoryBudget(uint64(*maxMemory) << 20)
+		log.Infof("Memory budget of %d MB: %d KB of PRData cache, the rest for the receive windows", *maxMemory, len(prData.data)>>10)
+	}
+	for _, route := range cgiRoutes {
+		if _, _, err := parseCGIRoute(route); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var rules urlRules
+	if len(*urlRulesFile) > 0 {
+		if rules, err = loadURLRules(*urlRulesFile); err != nil {
+			log.Fatalf("Unable to load the URL rules: %v", err)
+		}
+	}
+	for _, mount := range mounts {
+		if _, _, err := parseMount(mount); err != nil {
+			log.Fatal(err)
+		}
+	}
+	defaults := bindConfig{
+		handler: handlerConfig{
+			www:             *www,
+			mounts:          mounts,
+			urlRules:        rules,
+			dav:             *dav,
+			auth:            *auth,
+			proxyCache:      *proxyCache << 20,
+			proxyCacheDir:   *proxyCacheDir,
+			proxyClientCert: *proxyClientCert,
+			mirrorPercent:   *mirrorPercent,
+			fileChunkSize:   *fileChunkSize,
+			maxRequestBody:  *maxRequestBody << 20,
+			plugins:         plugins,
+			cgi:             cgiRoutes,
+			middlewares:     middlewareNames,
+			middleware: middlewareSettings{
+				corsOrigin: *corsOrigin,
+				rateLimit:  *rateLimit,
+				rateBurst:  *rateBurst,
+				csp:        *csp,
+				hstsMaxAge: *hstsMaxAge,
+			},
+		},
+		qlog:              *enableQlog,
+		qlogDir:           *qlogDir,
+		keyLogPerConn:     *keyLogPerConn,
+		h3FrameLog:        *h3FrameLog,
+		qlogEvents:        events,
+		retry:             *retry,
+		maxConnsPerIP:     *maxConnsPerIP,
+		connsPerIPWindow:  *connsPerIPWindow,
+		allow0RTT:         *allow0RTT,
+		earlyDataRoutes:   parseRoutes(*earlyDataRoutes),
+		noPMTUD:           *disablePMTUD,
+		tokenMaxAge:       *tokenMaxAge,
+		retryTokenMaxAge:  *retryTokenMaxAge,
+		tcp:               *tcp,
+		tcpListen:         *tcpListen,
+		httpsRedirect:     *httpsRedirect,
+		headerTimeout:     *headerTimeout,
+		streamIdleTimeout: *streamIdleTimeout,
+		alpns:             alpnList,
+		socksAllow:        socksAllowed,
+		drainTimeout:      *drainTimeout,
+	}
+	var keyLog io.Writer
+	if len(*keyLogFile) > 0 {
+		f, err := os.Create(*keyLogFile)
+		if err != nil {
+			log.Fatalf("Unable to create key log file %s: %v", *keyLogFile, err)
+		}
+		defer f.Close()
+		keyLog = f
+	}
+	// adapt the behavior to the quic-interop-runner environment
+	if testcase := os.Getenv("TESTCASE"); len(testcase) > 0 {
+		it, ok := interopTestcases[testcase]
+		if !ok {
+			fmt.Printf("unsupported test case: %s\n", testcase)
+			os.Exit(interopUnsupportedExitCode)
+		}
+		log.Infof("Running interop testcase %s", testcase)
+		defaults.hq = it.hq
+		defaults.retry = defaults.retry || it.retry
+		defaults.allow0RTT = defaults.allow0RTT || it.allow0RTT
+		if dir := os.Getenv("QLOGDIR"); len(dir) > 0 {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				log.Fatalf("Unable to create qlog dir %s: %v", dir, err)
+			}
+			defaults.qlog = true
+			defaults.qlogDir = dir
+		}
+		if filename := os.Getenv("SSLKEYLOGFILE"); len(filename) > 0 {
 			f, err := os.Create(filename)
 			if err != nil {
-				log.Fatal(err)
+				log.Fatalf("Unable to create key log file %s: %v", filename, err)
 			}
-			log.Infof("Creating qlog file %s", filename)
-			return qlog.NewConnectionTracer(NewBufferedWriteCloser(bufio.NewWriter(f), f), p, connID)
+			defer f.Close()
+			keyLog = f
 		}
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(len(bs))
-	for _, b := range bs {
-		log.Info("Start listening on " + b)
+	origin, err := parseProxyOrigin(*proxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defaults.handler.proxy = origin
+	if defaults.handler.mirror, err = parseProxyOrigin(*mirror); err != nil {
+		log.Fatal(err)
+	}
+	if defaults.handler.errorPages, err = newErrorPages(*errorPagesDir, *errorTemplate); err != nil {
+		log.Fatal(err)
+	}
+	if defaults.handler.geoip, err = newGeoIP(*geoipDB, *geoipASNDB, *geoipAllow, *geoipDeny); err != nil {
+		log.Fatal(err)
+	}
 
-		bCap := b
-		go func() {
-			var err error
-			if *tcp {
-				err = http3.ListenAndServe(bCap, *certFile, *keyFile, handler)
+	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+	if err != nil {
+		log.Fatalf("Unable to load cert/key files: %v", err)
+	}
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		KeyLogWriter: keyLog,
+	}
+	if !*resumption {
+		refuseResumption(tlsConf)
+	} else if *sessionCache > 0 {
+		newTLSSessionCache(*sessionCache).configure(tlsConf)
+	}
+	var watchedCerts []watchedCert
+	for _, der := range cert.Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			log.Fatalf("Invalid certificate in %s: %v", *certFile, err)
+		}
+		watchedCerts = append(watchedCerts, watchedCert{name: "server " + c.Subject.String(), cert: c})
+	}
+	if len(*clientCAFile) > 0 {
+		cas, err := loadCertificates(*clientCAFile)
+		if err != nil {
+			log.Fatalf("Unable to load the client CA certs: %v", err)
+		}
+		for _, ca := range cas {
+			watchedCerts = append(watchedCerts, watchedCert{name: "client-ca " + ca.Subject.String(), cert: ca})
+		}
+		tlsConf.ClientCAs = x509.NewCertPool()
+		for _, ca := range cas {
+			tlsConf.ClientCAs.AddCert(ca)
+		}
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+		if *clientAuthOptional {
+			tlsConf.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		checker, err := newRevocationChecker(*clientCRLFile, *clientOCSP, cas)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if checker != nil {
+			tlsConf.VerifyConnection = checker.verify
+		}
+	} else if len(*clientCRLFile) > 0 || len(*clientOCSP) > 0 {
+		log.Fatal("-client-crl and -client-ocsp require -client-ca")
+	}
+	if checkCertExpiry(watchedCerts, *certExpiryWarning) && *refuseExpired {
+		log.Fatal("Refusing to start with an expired certificate")
+	}
+
+	// open all the sockets before serving anything
+	loadInheritedSockets()
+	var bindConfs []bindConfig
+	activatedConns, activatedLns, err := activatedSockets()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(activatedConns) > 0 {
+		log.Infof("Using %d UDP and %d stream sockets from socket activation, -bind is ignored", len(activatedConns), len(activatedLns))
+		// with socket activation, the n-th stream socket is the TCP fallback of the n-th UDP socket
+		for i, conn := range activatedConns {
+			bc := defaults
+			bc.addr = conn.LocalAddr().String()
+			bc.conn = conn
+			if i < len(activatedLns) {
+				bc.tcp = true
+				bc.tcpLn = activatedLns[i]
 			} else {
-				server := http3.Server{
-					Handler:    handler,
-					Addr:       bCap,
-					QuicConfig: quicConf,
+				bc.tcp = false
+			}
+			bindConfs = append(bindConfs, bc)
+		}
+		for _, ln := range activatedLns[min(len(activatedConns), len(activatedLns)):] {
+			log.Warnf("Ignoring activated socket %s without matching UDP socket", ln.Addr())
+			ln.Close()
+		}
+	} else {
+		for _, b := range bs {
+			bc, err := parseBind(b, defaults)
+			if err != nil {
+				log.Fatal(err)
+			}
+			bcs, err := interfaceBinds(bc)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, bc := range bcs {
+				if err := bc.open(); err != nil {
+					log.Fatal(err)
 				}
-				err = server.ListenAndServeTLS(*certFile, *keyFile)
+				bindConfs = append(bindConfs, bc)
+			}
+		}
+	}
+	for _, bc := range bindConfs {
+		if len(bc.handler.dav) > 0 && !strings.Contains(bc.handler.auth, ":") {
+			log.Fatalf("WebDAV share on %s requires -auth user:password", bc.addr)
+		}
+		if slices.Contains(bc.alpns, socksALPN) && !strings.Contains(bc.handler.auth, ":") {
+			log.Fatalf("SOCKS5 gateway on %s requires -auth user:password", bc.addr)
+		}
+	}
+
+	var adminLn net.Listener
+	if len(*adminAddr) > 0 {
+		adminLn, err = inheritedListener("admin")
+		if adminLn == nil && err == nil {
+			adminLn, err = net.Listen("tcp", *adminAddr)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(*pidFile) > 0 {
+		if err := writePidFile(*pidFile); err != nil {
+			log.Fatalf("Unable to write pid file: %v", err)
+		}
+		// rewritten by the process started by a binary upgrade, which runs without the privileges
+		if err := chownToRunUser(*pidFile, *runUser, *runGroup); err != nil {
+			log.Warnf("Unable to give the pid file to -user: %v", err)
+		}
+		defer func() {
+			if err := removePidFile(*pidFile); err != nil {
+				log.Warnf("Unable to remove pid file: %v", err)
+			}
+		}()
+	}
+
+	if err := dropPrivileges(*runUser, *runGroup); err != nil {
+		log.Fatalf("Unable to drop privileges: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if config != nil {
+		setGeoIPRules := func() error {
+			if defaults.handler.geoip == nil {
+				return fmt.Errorf("the GeoIP options require -geoip-db")
+			}
+			defaults.handler.geoip.setRules(*geoipAllow, *geoipDeny)
+			return nil
+		}
+		setRateLimits := func() error {
+			return middlewareLimiters.setLimits(*rateLimit, *rateBurst)
+		}
+		config.reloadable = map[string]func() error{
+			"v":           setLogLevel,
+			"geoip-allow": setGeoIPRules,
+			"geoip-deny":  setGeoIPRules,
+			"rate-limit":  setRateLimits,
+			"rate-burst":  setRateLimits,
+		}
+		go func() {
+			if err := config.watch(ctx); err != nil {
+				log.Errorf("Unable to watch the config file: %v", err)
+			}
+		}()
+	}
+	if *certCheckInterval > 0 {
+		go watchCertExpiry(ctx, watchedCerts, *certCheckInterval, *certExpiryWarning)
+	}
+
+	var adminServer *http.Server
+	if adminLn != nil {
+		adminServer = newAdminServer()
+		log.Info("Start admin listening on " + adminLn.Addr().String())
+		go func() {
+			if err := adminServer.Serve(adminLn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Errorf("Admin server failed: %v", err)
+			}
+		}()
+		defer adminServer.Close()
+	}
+
+	// on SIGUSR2, a new process takes over the sockets and this one drains its connections
+	drain := make(chan struct{})
+	upgrades := make(chan os.Signal, 1)
+	notifyUpgrade(upgrades)
+	go func() {
+		for range upgrades {
+			if len(activatedConns) > 0 {
+				log.Error("Binary upgrades are not supported with socket activation, restart the service instead")
+				continue
+			}
+			log.Info("Starting a new process for the binary upgrade")
+			socks, err := upgradeSockets(bindConfs, adminLn)
+			if err == nil {
+				err = startUpgrade(socks)
 			}
 			if err != nil {
+				cancelHandovers(bindConfs)
+				log.Errorf("Binary upgrade failed: %v", err)
+				continue
+			}
+			log.Info("New process serving, draining the connections")
+			handOvers(bindConfs)
+			keepUnixSockets(bindConfs)
+			if adminServer != nil {
+				adminServer.Close()
+			}
+			close(drain)
+			return
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(len(bindConfs))
+	for _, bc := range bindConfs {
+		log.Info("Start listening on " + bc.addr)
+
+		bCap := bc
+		go func() {
+			if err := serveBind(ctx, bCap, tlsConf, drain); err != nil {
 				fmt.Println(err)
 			}
 			wg.Done()
 		}()
 	}
+	signalUpgradeReady()
 	wg.Wait()
+	log.Info("Server stopped")
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// the receive windows of quic-go when they are not configured
+const (
+	defaultInitialStreamWindow = 512 << 10
+	defaultMaxStreamWindow     = 6 << 20
+	defaultInitialConnWindow   = 768 << 10
+	defaultMaxConnWindow       = 15 << 20
+	// minConnWindow is the window left to the connections however many they are
+	minConnWindow = 64 << 10
+)
+
+const (
+	// defaultPRDataCache is the size of the start of the pseudo-random data kept in memory
+	defaultPRDataCache = 4 << 20
+	// prDataChunk is the size of the chunks the data past the cache is generated in
+	prDataChunk = 64 << 10
+)
+
+var (
+	memoryUsage           = newGaugeVec("quicgo_memory_budget_bytes", "Memory budget of -max-memory and its share in use, by use", "use")
+	windowIncreaseRefused = newCounterVec("quicgo_window_increases_refused_total", "Increases of the connection receive windows refused by reason", "reason")
+)
+
+// memBudget is the budget of -max-memory, nil when the memory is not limited
+var memBudget *memoryBudget
+
+// memoryBudget shares the memory of the receive windows between the connections: the windows of
+// a new connection are sized for the connections already open, and their windows only grow while
+// the sum of all the windows stays within the budget
+type memoryBudget struct {
+	windows uint64 // bytes available to the connection receive windows
+
+	mutex   sync.Mutex
+	used    uint64
+	charged map[uint64]uint64 // window bytes of each connection by tracing ID
+	// pending is the initial window given to the connection being accepted, quic-go creating its
+	// tracer right after its config
+	pending uint64
+}
+
+// newMemoryBudget splits total bytes between the PRData cache, which it resizes, and the receive windows
+func newMemoryBudget(total uint64) *memoryBudget {
+	cache := min(uint64(defaultPRDataCache), total/8)
+	prData = newPRDataCache(int(cache))
+	memoryUsage.set("limit", float64(total))
+	memoryUsage.set("prdata_cache", float64(cache))
+	return &memoryBudget{windows: total - cache, charged: make(map[uint64]uint64)}
+}
+
+// connWindow returns the maximum connection receive window of a new connection, n being open
+func (b *memoryBudget) connWindow(n int) uint64 {
+	return min(max(b.windows/uint64(n+1), minConnWindow), defaultMaxConnWindow)
+}
+
+// initialWindow returns the initial connection receive window of a new connection, within what is left of the budget
+func (b *memoryBudget) initialWindow() uint64 {
+	left := uint64(minConnWindow)
+	if b.used+minConnWindow < b.windows {
+		left = b.windows - b.used
+	}
+	return min(defaultInitialConnWindow, b.connWindow(len(b.charged)), left)
+}
+
+// limit sizes the windows of the connections accepted with conf from the budget
+func (b *memoryBudget) limit(conf *quic.Config) {
+	conf.AllowConnectionWindowIncrease = b.allowIncrease
+	base := conf.Clone()
+	conf.GetConfigForClient = func(*quic.ClientHelloInfo) (*quic.Config, error) {
+		b.mutex.Lock()
+		window := b.connWindow(len(b.charged))
+		b.pending = b.initialWindow()
+		initial := b.pending
+		b.mutex.Unlock()
+		c := base.Clone()
+		c.MaxConnectionReceiveWindow = window
+		c.InitialConnectionReceiveWindow = initial
+		c.MaxStreamReceiveWindow = min(defaultMaxStreamWindow, window)
+		c.InitialStreamReceiveWindow = min(defaultInitialStreamWindow, initial)
+		return c, nil
+	}
+}
+
+// tracer charges the initial window of the connections to the budget, and releases their windows once closed
+func (b *memoryBudget) tracer(ctx context.Context, _ logging.Perspective, _ quic.ConnectionID) *logging.ConnectionTracer {
+	tracingID, _ := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	b.mutex.Lock()
+	initial := b.pending
+	if b.pending = 0; initial == 0 {
+		initial = b.initialWindow()
+	}
+	b.charged[tracingID] = initial
+	b.used += initial
+	memoryUsage.set("receive_windows", float64(b.used))
+	b.mutex.Unlock()
+	return &logging.ConnectionTracer{
+		Close: func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			b.used -= b.charged[tracingID]
+			delete(b.charged, tracingID)
+			memoryUsage.set("receive_windows", float64(b.used))
+		},
+	}
+}
+
+// allowIncrease lets the window of conn grow by delta if the budget has room for it
+func (b *memoryBudget) allowIncrease(conn quic.Connection, delta uint64) bool {
+	tracingID, _ := conn.Context().Value(quic.ConnectionTracingKey).(uint64)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.charged[tracingID]; !ok {
+		return false
+	}
+	if b.used+delta > b.windows {
+		windowIncreaseRefused.inc("memory_budget")
+		return false
+	}
+	b.used += delta
+	b.charged[tracingID] += delta
+	memoryUsage.set("receive_windows", float64(b.used))
+	return true
+}
+
+// prDataCache is the start of the data of generatePRData, shared by the responses instead of
+// generated for each one
+type prDataCache struct {
+	data []byte
+	seed uint64 // state of the generator at the end of data
+}
+
+var prData = newPRDataCache(defaultPRDataCache)
+
+func newPRDataCache(size int) *prDataCache {
+	c := &prDataCache{data: make([]byte, size), seed: 1}
+	c.seed = fillPRData(c.data, c.seed)
+	return c
+}
+
+// fillPRData fills b with the Lehmer generator from seed and returns its next state
+func fillPRData(b []byte, seed uint64) uint64 {
+	for i := range b {
+		seed = seed * 48271 % 2147483647
+		b[i] = byte(seed)
+	}
+	return seed
+}
+
+// bytes returns the first n bytes of the data, not to be modified
+func (c *prDataCache) bytes(n int) []byte {
+	if n <= len(c.data) {
+		return c.data[:n]
+	}
+	return generatePRData(n)
+}
+
+// writeTo writes the first n bytes of the data to w, generating the part past the cache chunk by chunk
+func (c *prDataCache) writeTo(w io.Writer, n int64) error {
+	if n <= int64(len(c.data)) {
+		_, err := w.Write(c.data[:n])
+		return err
+	}
+	if _, err := w.Write(c.data); err != nil {
+		return err
+	}
+	n -= int64(len(c.data))
+	chunk := make([]byte, prDataChunk)
+	seed := c.seed
+	for n > 0 {
+		b := chunk[:min(int64(len(chunk)), n)]
+		seed = fillPRData(b, seed)
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		n -= int64(len(b))
+	}
+	return nil
+}
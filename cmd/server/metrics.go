@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// metric is a metric family exposed on the admin /metrics endpoint
+type metric interface {
+	metricName() string
+	writeMetric(w io.Writer)
+}
+
+// registeredMetrics holds every metric family, they are registered when the package is initialized
+var registeredMetrics []metric
+
+// counterVec is a set of counters sharing a name, distinguished by the value of one label
+type counterVec struct {
+	name   string
+	help   string
+	label  string
+	mutex  sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	c := &counterVec{name: name, help: help, label: label, values: make(map[string]uint64)}
+	registeredMetrics = append(registeredMetrics, c)
+	return c
+}
+
+func (c *counterVec) add(value string, n uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[value] += n
+}
+
+func (c *counterVec) inc(value string) {
+	c.add(value, 1)
+}
+
+func (c *counterVec) metricName() string {
+	return c.name
+}
+
+func (c *counterVec) writeMetric(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	values := make([]string, 0, len(c.values))
+	for v := range c.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", c.name, c.label, v, c.values[v])
+	}
+}
+
+// gaugeVec is a set of gauges sharing a name, distinguished by the value of one label
+type gaugeVec struct {
+	name   string
+	help   string
+	label  string
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func newGaugeVec(name, help, label string) *gaugeVec {
+	g := &gaugeVec{name: name, help: help, label: label, values: make(map[string]float64)}
+	registeredMetrics = append(registeredMetrics, g)
+	return g
+}
+
+func (g *gaugeVec) set(value string, v float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.values[value] = v
+}
+
+func (g *gaugeVec) metricName() string {
+	return g.name
+}
+
+func (g *gaugeVec) writeMetric(w io.Writer) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	values := make([]string, 0, len(g.values))
+	for v := range g.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		fmt.Fprintf(w, "%s{%s=%q} %g\n", g.name, g.label, v, g.values[v])
+	}
+}
+
+// metricsHandler writes all the registered metrics in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics := append([]metric(nil), registeredMetrics...)
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].metricName() < metrics[j].metricName() })
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range metrics {
+		m.writeMetric(w)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"compress/gzip"
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// middleware wraps a handler to act on all its requests
+type middleware func(http.Handler) http.Handler
+
+// middlewareSettings holds the options of the middlewares which can be enabled with -middlewares
+type middlewareSettings struct {
+	corsOrigin string  // value of Access-Control-Allow-Origin
+	rateLimit  float64 // requests per second allowed for each client IP
+	rateBurst  int
+	csp        string        // Content-Security-Policy of the security middleware, empty to leave it out
+	hstsMaxAge time.Duration // max-age of the Strict-Transport-Security header, 0 to leave it out
+}
+
+// middlewareFactories are the middlewares available in -middlewares, by name
+var middlewareFactories = map[string]func(conf handlerConfig) (middleware, error){
+	"log": func(conf handlerConfig) (middleware, error) {
+		return func(next http.Handler) http.Handler { return accessLog(conf.geoip, next) }, nil
+	},
+	"auth": func(conf handlerConfig) (middleware, error) {
+		if !strings.Contains(conf.auth, ":") {
+			return nil, fmt.Errorf("the auth middleware requires -auth user:password")
+		}
+		return func(next http.Handler) http.Handler { return basicAuth(conf.auth, next) }, nil
+	},
+	"cors": func(conf handlerConfig) (middleware, error) {
+		return func(next http.Handler) http.Handler { return cors(conf.middleware.corsOrigin, next) }, nil
+	},
+	"gzip": func(handlerConfig) (middleware, error) {
+		return compress, nil
+	},
+	"security": func(conf handlerConfig) (middleware, error) {
+		return func(next http.Handler) http.Handler {
+			return securityHeaders(conf.middleware.csp, conf.middleware.hstsMaxAge, next)
+		}, nil
+	},
+	"ratelimit": func(conf handlerConfig) (middleware, error) {
+		if conf.middleware.rateLimit <= 0 || conf.middleware.rateBurst <= 0 {
+			return nil, fmt.Errorf("the ratelimit middleware requires a positive -rate-limit and -rate-burst")
+		}
+		limiter := newRateLimiter(conf.middleware.rateLimit, conf.middleware.rateBurst)
+		middlewareLimiters.add(limiter)
+		return limiter.handler, nil
+	},
+}
+
+// parseMiddlewares parses a comma separated list of middleware names
+func parseMiddlewares(v string) ([]string, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := middlewareFactories[name]; !ok {
+			return nil, fmt.Errorf("unknown middleware %s", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// chainMiddlewares wraps next with the middlewares of conf, the first one listed sees the requests first
+func chainMiddlewares(conf handlerConfig, next http.Handler) (http.Handler, error) {
+	for i := len(conf.middlewares) - 1; i >= 0; i-- {
+		m, err := middlewareFactories[conf.middlewares[i]](conf)
+		if err != nil {
+			return nil, err
+		}
+		next = m(next)
+	}
+	return next, nil
+}
+
+// wrappedWriter is implemented by the response writers of the middlewares
+type wrappedWriter interface {
+	http.ResponseWriter
+	http.Flusher
+	Unwrap() http.ResponseWriter
+}
+
+// keepHijacker returns wrapped with the http3.Hijacker or http.Hijacker interface of w, when w has it,
+// so the handlers can still reach the QUIC connection of HTTP/3 requests, or take over the TCP
+// connection of HTTP/1.1 ones (e.g. for WebSockets)
+func keepHijacker(w http.ResponseWriter, wrapped wrappedWriter) http.ResponseWriter {
+	if h, ok := w.(http3.Hijacker); ok {
+		return struct {
+			wrappedWriter
+			http3.Hijacker
+		}{wrapped, h}
+	}
+	if h, ok := w.(http.Hijacker); ok {
+		return struct {
+			wrappedWriter
+			http.Hijacker
+		}{wrapped, h}
+	}
+	return wrapped
+}
+
+// statusRecorder notes the status and the size of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// accessLog logs every request once it has been served, with the country and AS of the client when geo is set
+func accessLog(geo *geoIP, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(keepHijacker(w, rec), r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+		l := loggerFrom(r.Context())
+		if geo != nil {
+			l.Infof("%s [%s] %s %s %s %d %d %v", r.RemoteAddr, geo.lookupAddr(r.RemoteAddr), r.Proto, r.Method, r.RequestURI, rec.status, rec.bytes, elapsed)
+			return
+		}
+		l.Infof("%s %s %s %s %d %d %v", r.RemoteAddr, r.Proto, r.Method, r.RequestURI, rec.status, rec.bytes, elapsed)
+	})
+}
+
+// cors allows the pages of origin to call the server, and answers the preflight requests
+func cors(origin string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if origin != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// securityHeaders sets the security headers on all the responses, unless the handler replaces them
+func securityHeaders(csp string, hstsMaxAge time.Duration, next http.Handler) http.Handler {
+	hsts := fmt.Sprintf("max-age=%d", int64(hstsMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		if hstsMaxAge > 0 {
+			// ignored by the browsers on plain HTTP, which the unix sockets serve behind a TLS proxy
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if len(csp) > 0 {
+			h.Set("Content-Security-Policy", csp)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" ||
+		mediaType == "application/javascript" || mediaType == "application/xml" || mediaType == "image/svg+xml"
+}
+
+// gzipWriter compresses the response body when its content type is worth it
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer // nil until the response is known to be compressed
+	wroteHeader bool
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if status == http.StatusOK && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *gzipWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// compress gzips the textual responses for the clients accepting it
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Header.Get("Range") != "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		next.ServeHTTP(keepHijacker(w, gw), r)
+		if gw.gz != nil {
+			gw.gz.Close()
+		}
+	})
+}
+
+// maxRateBuckets bounds the clients tracked by a rateLimiter, the least recently seen ones being forgotten
+const maxRateBuckets = 10000
+
+// tokenBucket holds the requests a client can still send
+type tokenBucket struct {
+	ip     string
+	tokens float64
+	last   time.Time
+	elem   *list.Element
+}
+
+// rateLimiter limits the request rate of each client IP with a token bucket
+type rateLimiter struct {
+	mutex   sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	lru     *list.List // most recently seen clients first
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket), lru: list.New()}
+}
+
+// setLimits changes the rate and burst, the buckets keep their tokens
+func (l *rateLimiter) setLimits(rate float64, burst int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rate, l.burst = rate, float64(burst)
+}
+
+// rateLimiters are the limiters of the ratelimit middleware of all the binds, updated on a config reload
+type rateLimiters struct {
+	mutex    sync.Mutex
+	limiters []*rateLimiter
+}
+
+var middlewareLimiters rateLimiters
+
+func (ls *rateLimiters) add(l *rateLimiter) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	ls.limiters = append(ls.limiters, l)
+}
+
+func (ls *rateLimiters) setLimits(rate float64, burst int) error {
+	if rate <= 0 || burst <= 0 {
+		return fmt.Errorf("the ratelimit middleware requires a positive -rate-limit and -rate-burst")
+	}
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	for _, l := range ls.limiters {
+		l.setLimits(rate, burst)
+	}
+	return nil
+}
+
+// allow takes a token from the bucket of ip, or returns how long to wait for the next one
+func (l *rateLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// forget the least recently seen clients whose bucket is full again, which are idle, and
+	// past maxRateBuckets the least recently seen ones, as with spoofed or rotating addresses
+	for elem := l.lru.Back(); elem != nil; elem = l.lru.Back() {
+		b := elem.Value.(*tokenBucket)
+		if len(l.buckets) < maxRateBuckets && b.tokens+now.Sub(b.last).Seconds()*l.rate < l.burst {
+			break
+		}
+		l.lru.Remove(elem)
+		delete(l.buckets, b.ip)
+	}
+
+	b, ok := l.buckets[ip]
+	if ok {
+		l.lru.MoveToFront(b.elem)
+	} else {
+		b = &tokenBucket{ip: ip, tokens: l.burst, last: now}
+		b.elem = l.lru.PushFront(b)
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if ok, wait := l.allow(ip, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(10, 2)
+	now := time.Now()
+	for i, expected := range []bool{true, true, false} {
+		if ok, _ := l.allow("192.0.2.1", now); ok != expected {
+			t.Errorf("request %d allowed %v, expected %v", i, ok, expected)
+		}
+	}
+	if ok, wait := l.allow("192.0.2.1", now.Add(50*time.Millisecond)); ok || wait != 50*time.Millisecond {
+		t.Errorf("allowed %v before the next token, in %v", ok, wait)
+	}
+	if ok, _ := l.allow("192.0.2.1", now.Add(100*time.Millisecond)); !ok {
+		t.Error("refused with a new token")
+	}
+	if ok, _ := l.allow("192.0.2.2", now); !ok {
+		t.Error("other client refused")
+	}
+
+	// the bucket of 192.0.2.2 is full again
+	l.allow("192.0.2.3", now.Add(time.Second))
+	if _, ok := l.buckets["192.0.2.2"]; ok {
+		t.Error("idle client kept")
+	}
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	l := newRateLimiter(1, 5)
+	now := time.Now()
+	l.allow("192.0.2.1", now)
+	for i := 0; i < 2*maxRateBuckets; i++ {
+		l.allow("2001:db8::"+strconv.FormatInt(int64(i), 16), now)
+		if i%(maxRateBuckets/4) == 0 {
+			// seen again, kept as the others are forgotten
+			l.allow("192.0.2.1", now)
+		}
+	}
+	if len(l.buckets) != maxRateBuckets || l.lru.Len() != maxRateBuckets {
+		t.Errorf("%d buckets, %d in the LRU, expected %d", len(l.buckets), l.lru.Len(), maxRateBuckets)
+	}
+	if b, ok := l.buckets["192.0.2.1"]; !ok || b.tokens != 0 {
+		t.Error("recently seen client forgotten")
+	}
+	if _, ok := l.buckets["2001:db8::0"]; ok {
+		t.Error("least recently seen client kept")
+	}
+}
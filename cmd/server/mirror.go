@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// maxMirroredBody is the size of the largest request body mirrored, the larger requests are not mirrored
+	maxMirroredBody = 1 << 20
+	// maxMirrorsInFlight is the number of mirrored requests waiting for the shadow backend, the next ones are dropped
+	maxMirrorsInFlight = 64
+	mirrorTimeout      = 10 * time.Second
+)
+
+var mirroredRequests = newCounterVec("quicgo_mirrored_requests_total", "Requests mirrored to the shadow backend by result", "result")
+
+// mirror sends a copy of a share of the requests to a shadow backend, its responses are discarded
+type mirror struct {
+	shadow   *url.URL
+	percent  float64
+	client   *http.Client
+	inFlight chan struct{}
+}
+
+func newMirror(shadow *url.URL, percent float64) *mirror {
+	return &mirror{
+		shadow:  shadow,
+		percent: percent,
+		client: &http.Client{
+			Timeout: mirrorTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		inFlight: make(chan struct{}, maxMirrorsInFlight),
+	}
+}
+
+// handler serves the requests with next and mirrors them without delaying the responses
+func (m *mirror) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.percent < 100 && rand.Float64()*100 >= m.percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxMirroredBody+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rest := r.Body
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), rest))
+		if len(body) > maxMirroredBody {
+			mirroredRequests.inc("too_large")
+		} else {
+			select {
+			case m.inFlight <- struct{}{}:
+				go m.send(r, body)
+			default:
+				mirroredRequests.inc("dropped")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *mirror) send(r *http.Request, body []byte) {
+	defer func() { <-m.inFlight }()
+	u := *m.shadow
+	u.Path, u.RawPath = r.URL.Path, r.URL.RawPath
+	u.RawQuery = r.URL.RawQuery
+	req, err := http.NewRequest(r.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		mirroredRequests.inc("error")
+		return
+	}
+	req.Header = r.Header.Clone()
+	deleteClientCertHeaders(req.Header)
+	req.Header.Set("X-Shadow-Request", "1")
+	if len(body) == 0 {
+		req.Body = http.NoBody
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		loggerFrom(r.Context()).Debugf("Mirrored request %s %s failed: %v", r.Method, u.String(), err)
+		mirroredRequests.inc("error")
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	mirroredRequests.inc("sent")
+}
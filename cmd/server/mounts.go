@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// parseMount parses a "/prefix=/path/to/dir" -mount entry, returning the prefix without its trailing slash
+func parseMount(v string) (string, string, error) {
+	prefix, dir, found := strings.Cut(v, "=")
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !found || !strings.HasPrefix(prefix, "/") || len(dir) == 0 {
+		return "", "", fmt.Errorf("invalid mount %q, expected /prefix=/path/to/dir (-www serves /)", v)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", "", fmt.Errorf("invalid mount %q: %s is not a directory", v, dir)
+	}
+	return prefix, dir, nil
+}
+
+// registerMounts serves the directories of the -mount entries of conf under their prefix, the
+// requests of the prefix without its trailing slash being redirected by the mux
+func registerMounts(mux *http.ServeMux, conf handlerConfig) error {
+	for _, mount := range conf.mounts {
+		prefix, dir, err := parseMount(mount)
+		if err != nil {
+			return err
+		}
+		var files http.Handler = http.FileServer(http.Dir(dir))
+		if conf.fileChunkSize > 0 {
+			files = newFileStreamer(conf.fileChunkSize).handler(files)
+		}
+		if err := handle(mux, prefix+"/", http.StripPrefix(prefix, files)); err != nil {
+			return fmt.Errorf("mount %s: %w", mount, err)
+		}
+		log.Infof("Serving %s under %s/", dir, prefix)
+	}
+	return nil
+}
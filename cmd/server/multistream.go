@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxMultistreamStreams bounds the number of streams of a multistream session
+	maxMultistreamStreams = 1000
+	// multistreamSessionTTL is how long a multistream session is kept after its creation
+	multistreamSessionTTL = 10 * time.Minute
+	// multistreamReportWait is how long the report waits for the streams still running
+	multistreamReportWait = 5 * time.Second
+)
+
+type streamResult struct {
+	Stream         int     `json:"stream"`
+	Bytes          int64   `json:"bytes"`
+	StartSeconds   float64 `json:"start_seconds"` // relative to the start of the first stream of the session
+	EndSeconds     float64 `json:"end_seconds"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	GoodputMbps    float64 `json:"goodput_mbps"`
+}
+
+type multistreamReport struct {
+	Session         string         `json:"session"`
+	Streams         int            `json:"streams"`
+	Complete        bool           `json:"complete"`
+	Results         []streamResult `json:"results"`
+	TotalBytes      int64          `json:"total_bytes"`
+	CompletionTime  float64        `json:"completion_seconds"`
+	AggregateMbps   float64        `json:"aggregate_goodput_mbps"`
+	FairnessJainIdx float64        `json:"fairness_jain_index"`
+}
+
+// multistreamSession collects the results of the N streams opened by a client for one session
+type multistreamSession struct {
+	streams int
+	created time.Time
+	start   time.Time // start of the first stream
+	results []streamResult
+	done    chan struct{} // closed once all the streams completed
+}
+
+type multistreamBench struct {
+	mutex    sync.Mutex
+	sessions map[string]*multistreamSession
+}
+
+func newMultistreamBench() *multistreamBench {
+	return &multistreamBench{sessions: make(map[string]*multistreamSession)}
+}
+
+// session returns the session with the given ID, creating it if needed
+func (b *multistreamBench) session(id string, streams int, now time.Time) *multistreamSession {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for sid, s := range b.sessions {
+		if now.Sub(s.created) > multistreamSessionTTL {
+			delete(b.sessions, sid)
+		}
+	}
+	s, ok := b.sessions[id]
+	if !ok {
+		s = &multistreamSession{
+			streams: streams,
+			created: now,
+			start:   now,
+			done:    make(chan struct{}),
+		}
+		b.sessions[id] = s
+	}
+	return s
+}
+
+func (b *multistreamBench) record(s *multistreamSession, stream int, n int64, start, end time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if start.Before(s.start) {
+		s.start = start
+	}
+	s.results = append(s.results, streamResult{
+		Stream:         stream,
+		Bytes:          n,
+		StartSeconds:   start.Sub(s.created).Seconds(),
+		EndSeconds:     end.Sub(s.created).Seconds(),
+		ElapsedSeconds: end.Sub(start).Seconds(),
+		GoodputMbps:    goodputMbps(n, end.Sub(start)),
+	})
+	if len(s.results) == s.streams {
+		close(s.done)
+	}
+}
+
+func (b *multistreamBench) report(id string, s *multistreamSession) multistreamReport {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	rep := multistreamReport{
+		Session:  id,
+		Streams:  s.streams,
+		Complete: len(s.results) == s.streams,
+		Results:  make([]streamResult, len(s.results)),
+	}
+	offset := s.start.Sub(s.created).Seconds()
+	var sum, sumSquares float64
+	for i, res := range s.results {
+		res.StartSeconds -= offset
+		res.EndSeconds -= offset
+		rep.Results[i] = res
+		rep.TotalBytes += res.Bytes
+		rep.CompletionTime = max(rep.CompletionTime, res.EndSeconds)
+		sum += res.GoodputMbps
+		sumSquares += res.GoodputMbps * res.GoodputMbps
+	}
+	if rep.CompletionTime > 0 {
+		rep.AggregateMbps = float64(rep.TotalBytes) * 8 / rep.CompletionTime / 1e6
+	}
+	if sumSquares > 0 {
+		rep.FairnessJainIdx = sum * sum / (float64(len(s.results)) * sumSquares)
+	}
+	return rep
+}
+
+// streamHandler sends size bytes for one of the streams of a session:
+// /bench/multistream?session=ID&streams=N&stream=I&size=S
+func (b *multistreamBench) streamHandler(w http.ResponseWriter, r *http.Request) {
+	const maxSize = 1 << 30 // 1 GB
+	q := r.URL.Query()
+	id := q.Get("session")
+	streams, err1 := strconv.Atoi(q.Get("streams"))
+	stream, err2 := strconv.Atoi(q.Get("stream"))
+	size, err3 := strconv.ParseInt(q.Get("size"), 10, 64)
+	if id == "" || err1 != nil || err2 != nil || err3 != nil ||
+		streams <= 0 || streams > maxMultistreamStreams || stream < 0 || stream >= streams ||
+		size <= 0 || size > maxSize {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	s := b.session(id, streams, start)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	data := prData.bytes(benchBufferSize)
+	var n int64
+	for n < size {
+		written, err := w.Write(data[:min(int64(len(data)), size-n)])
+		n += int64(written)
+		if err != nil {
+			break
+		}
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	b.record(s, stream, n, start, time.Now())
+}
+
+// reportHandler returns the per-stream and aggregate results of a session, waiting a bit for
+// the streams still running: /bench/multistream/report?session=ID
+func (b *multistreamBench) reportHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("session")
+	b.mutex.Lock()
+	s, ok := b.sessions[id]
+	b.mutex.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	select {
+	case <-s.done:
+	case <-time.After(multistreamReportWait):
+	case <-r.Context().Done():
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b.report(id, s))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cgi"
+	"plugin"
+	"strings"
+)
+
+// pluginSymbol is the function a Go plugin exports to provide its handlers, by mux pattern:
+//
+//	func Handlers() map[string]http.Handler
+const pluginSymbol = "Handlers"
+
+// repeated collects the values of a flag given several times
+type repeated []string
+
+func (r *repeated) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *repeated) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
+
+func (r *repeated) replace(values []string) {
+	*r = append(repeated(nil), values...)
+}
+
+// loadPlugin opens the Go plugin at path and returns its handlers.
+// The plugin must be built with the same Go version and dependencies as the server.
+func loadPlugin(path string) (map[string]http.Handler, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(pluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	handlers, ok := sym.(func() map[string]http.Handler)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s: %s is not a func() map[string]http.Handler", path, pluginSymbol)
+	}
+	return handlers(), nil
+}
+
+// parseCGIRoute parses a "/pattern=/path/to/program" -cgi entry
+func parseCGIRoute(v string) (string, http.Handler, error) {
+	pattern, program, found := strings.Cut(v, "=")
+	if !found || !strings.HasPrefix(pattern, "/") || len(program) == 0 {
+		return "", nil, fmt.Errorf("invalid cgi route %q, expected /pattern=/path/to/program", v)
+	}
+	return pattern, &cgi.Handler{
+		Path: program,
+		Root: strings.TrimSuffix(pattern, "/"),
+	}, nil
+}
+
+// handle registers h on mux, returning an error instead of panicking when pattern is already taken
+func handle(mux *http.ServeMux, pattern string, h http.Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	mux.Handle(pattern, h)
+	return nil
+}
+
+// registerExternalHandlers adds to mux the handlers of the Go plugins and the CGI programs of conf
+func registerExternalHandlers(mux *http.ServeMux, conf handlerConfig) error {
+	for _, path := range conf.plugins {
+		handlers, err := loadPlugin(path)
+		if err != nil {
+			return fmt.Errorf("unable to load plugin %s: %w", path, err)
+		}
+		for pattern, h := range handlers {
+			if err := handle(mux, pattern, h); err != nil {
+				return fmt.Errorf("plugin %s: %w", path, err)
+			}
+			log.Infof("Plugin %s handles %s", path, pattern)
+		}
+	}
+	for _, route := range conf.cgi {
+		pattern, h, err := parseCGIRoute(route)
+		if err != nil {
+			return err
+		}
+		if err := handle(mux, pattern, h); err != nil {
+			return fmt.Errorf("cgi route %s: %w", route, err)
+		}
+		log.Infof("CGI program %s handles %s", h.(*cgi.Handler).Path, pattern)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func dropPrivileges(userName, groupName string) error {
+	if len(userName) == 0 && len(groupName) == 0 {
+		return nil
+	}
+	return errors.New("dropping privileges is not supported on this platform")
+}
+
+func chownToRunUser(path, userName, groupName string) error {
+	return nil
+}
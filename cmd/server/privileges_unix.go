@@ -0,0 +1,84 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// lookupIDs returns the ids of the given user and group, -1 for those not given.
+// When only the user is given, its primary group is used.
+func lookupIDs(userName, groupName string) (int, int, error) {
+	uid, gid := -1, -1
+	if len(userName) > 0 {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return uid, gid, err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return uid, gid, fmt.Errorf("unsupported uid %s: %w", u.Uid, err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return uid, gid, fmt.Errorf("unsupported gid %s: %w", u.Gid, err)
+		}
+	}
+	if len(groupName) > 0 {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return uid, gid, err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return uid, gid, fmt.Errorf("unsupported gid %s: %w", g.Gid, err)
+		}
+	}
+	return uid, gid, nil
+}
+
+// chownToRunUser gives the file at path to the user and group the privileges are dropped to,
+// for the process to keep writing it
+func chownToRunUser(path, userName, groupName string) error {
+	if len(userName) == 0 && len(groupName) == 0 {
+		return nil
+	}
+	uid, gid, err := lookupIDs(userName, groupName)
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// dropPrivileges switches the process to the given user and group.
+// When only the user is given, its primary group is used.
+func dropPrivileges(userName, groupName string) error {
+	if len(userName) == 0 && len(groupName) == 0 {
+		return nil
+	}
+	uid, gid, err := lookupIDs(userName, groupName)
+	if err != nil {
+		return err
+	}
+
+	// the process started by a binary upgrade already runs with them
+	if (uid < 0 || uid == syscall.Getuid()) && gid == syscall.Getgid() {
+		return nil
+	}
+
+	// the group must be changed first, we are not allowed to do it anymore once the uid changed
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid: %w", err)
+		}
+	}
+	log.Infof("Dropped privileges to uid %d, gid %d", syscall.Getuid(), syscall.Getgid())
+	return nil
+}
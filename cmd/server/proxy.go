@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// newProxyHandler creates a reverse proxy forwarding every request to origin,
+// with the identity of the mTLS clients in X-Client-Cert-* headers when clientCertHeaders is set
+func newProxyHandler(origin *url.URL, clientCertHeaders bool) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(origin)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = origin.Host
+		deleteClientCertHeaders(r.Header)
+		if clientCertHeaders {
+			setClientCertHeaders(r)
+		}
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		loggerFrom(r.Context()).Errorf("Proxy request %s %s failed: %v", r.Method, r.URL, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	return proxy
+}
+
+// parseProxyOrigin parses the origin URL of the reverse proxy mode, an empty value disables it
+func parseProxyOrigin(v string) (*url.URL, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	origin, err := url.Parse(v)
+	if err != nil || origin.Host == "" {
+		return nil, fmt.Errorf("invalid proxy origin %s", v)
+	}
+	return origin, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestProxyClientCertHeaders(t *testing.T) {
+	var received http.Header
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer origin.Close()
+	originURL, _ := url.Parse(origin.URL)
+	id := &clientIdentity{Subject: "CN=client", Issuer: "CN=ca", Fingerprint: "00ff"}
+
+	tests := []struct {
+		name              string
+		clientCertHeaders bool
+		id                *clientIdentity
+		subject           string
+	}{
+		{"disabled", false, id, ""},
+		{"without certificate", true, nil, ""},
+		{"with certificate", true, id, "CN=client"},
+	}
+	for _, test := range tests {
+		received = nil
+		r := httptest.NewRequest(http.MethodGet, "https://proxy/a", nil)
+		r.Header.Set("X-Client-Cert-Subject", "CN=forged")
+		r.Header.Set("X-Client-Cert-Fingerprint", "forged")
+		if test.id != nil {
+			r = r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, test.id))
+		}
+		newProxyHandler(originURL, test.clientCertHeaders).ServeHTTP(httptest.NewRecorder(), r)
+		if received == nil {
+			t.Fatalf("%s: request not proxied", test.name)
+		}
+		if subject := received.Get("X-Client-Cert-Subject"); subject != test.subject {
+			t.Errorf("%s: X-Client-Cert-Subject %q, expected %q", test.name, subject, test.subject)
+		}
+		if fingerprint := received.Get("X-Client-Cert-Fingerprint"); fingerprint == "forged" {
+			t.Errorf("%s: forged X-Client-Cert-Fingerprint proxied", test.name)
+		}
+	}
+}
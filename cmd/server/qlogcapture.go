@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/logging"
+	"github.com/quic-go/quic-go/qlog"
+)
+
+// qlogCaptureHeader is the request header starting the qlog capture of its connection, honored in debug mode
+const qlogCaptureHeader = "X-Qlog-Capture"
+
+// qlogCaptures are the live connections whose qlog can be written on demand, when -qlog is not set
+type qlogCaptures struct {
+	// enabled is set when the admin server is enabled or the log level is debug at startup, the
+	// connections being registered then: their tracer forwards every event under a lock
+	enabled bool
+
+	mutex sync.Mutex
+	conns map[string]*liveConn // by connection ID
+	// byTracingID finds the connection of a request, from the tracing ID of its context
+	byTracingID map[uint64]*liveConn
+}
+
+var onDemandQlogs = &qlogCaptures{conns: make(map[string]*liveConn), byTracingID: make(map[uint64]*liveConn)}
+
+// liveConn is a connection which writes its qlog once a capture is started
+type liveConn struct {
+	id          quic.ConnectionID
+	perspective logging.Perspective
+	dir         string
+	events      map[string]bool
+	opened      time.Time
+
+	mutex sync.RWMutex
+	// the arguments of StartedConnection, given again to the qlog tracer of a capture started later
+	local, remote       net.Addr
+	srcConnID, destConn logging.ConnectionID
+	qlog                *logging.ConnectionTracer // nil until captured
+	filename            string
+	captures            int // number of captures started, each one written to its own file
+}
+
+// tracer registers the connections, writing their qlog in dir with only the events of the given categories
+func (c *qlogCaptures) tracer(dir string, events map[string]bool) func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer {
+	return func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+		conn := &liveConn{id: connID, perspective: p, dir: dir, events: events, opened: time.Now()}
+		tracingID, _ := ctx.Value(quic.ConnectionTracingKey).(uint64)
+		c.mutex.Lock()
+		c.conns[connID.String()] = conn
+		c.byTracingID[tracingID] = conn
+		c.mutex.Unlock()
+		t := conn.tracer()
+		t.Close = func() {
+			c.mutex.Lock()
+			delete(c.conns, connID.String())
+			delete(c.byTracingID, tracingID)
+			c.mutex.Unlock()
+			conn.stop()
+		}
+		return t
+	}
+}
+
+// get returns the live connection of ID id, nil when it is closed or unknown
+func (c *qlogCaptures) get(id string) *liveConn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conns[id]
+}
+
+// start opens the qlog file of the connection, doing nothing when it is already captured
+func (c *liveConn) start() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.qlog != nil {
+		return c.filename, nil
+	}
+	name := fmt.Sprintf("server_%s.qlog", c.id)
+	if c.captures > 0 {
+		name = fmt.Sprintf("server_%s_%d.qlog", c.id, c.captures+1)
+	}
+	filename := filepath.Join(c.dir, name)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	c.captures++
+	log.Infof("Creating qlog file %s for the live connection %s", filename, c.id)
+	c.qlog = filterQlogEvents(qlog.NewConnectionTracer(NewBufferedWriteCloser(bufio.NewWriter(f), f), c.perspective, c.id), c.events)
+	c.filename = filename
+	if c.remote != nil && c.qlog.StartedConnection != nil {
+		// the trace misses what happened before, but not the addresses and connection IDs
+		c.qlog.StartedConnection(c.local, c.remote, c.srcConnID, c.destConn)
+	}
+	return filename, nil
+}
+
+// stop closes the qlog file of the connection, when captured
+func (c *liveConn) stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.qlog == nil {
+		return
+	}
+	if c.qlog.Close != nil {
+		c.qlog.Close()
+	}
+	log.Infof("Closed qlog file %s", c.filename)
+	c.qlog = nil
+}
+
+// with calls f with the qlog tracer of the connection while captured
+func (c *liveConn) with(f func(t *logging.ConnectionTracer)) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.qlog != nil {
+		f(c.qlog)
+	}
+}
+
+// tracer forwards the events to the qlog tracer of the connection, once a capture is started
+func (c *liveConn) tracer() *logging.ConnectionTracer {
+	return &logging.ConnectionTracer{
+		StartedConnection: func(local, remote net.Addr, srcConnID, destConnID logging.ConnectionID) {
+			c.mutex.Lock()
+			c.local, c.remote, c.srcConnID, c.destConn = local, remote, srcConnID, destConnID
+			c.mutex.Unlock()
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.StartedConnection != nil {
+					t.StartedConnection(local, remote, srcConnID, destConnID)
+				}
+			})
+		},
+		NegotiatedVersion: func(chosen logging.VersionNumber, clientVersions, serverVersions []logging.VersionNumber) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.NegotiatedVersion != nil {
+					t.NegotiatedVersion(chosen, clientVersions, serverVersions)
+				}
+			})
+		},
+		ClosedConnection: func(err error) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.ClosedConnection != nil {
+					t.ClosedConnection(err)
+				}
+			})
+		},
+		SentTransportParameters: func(tp *logging.TransportParameters) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.SentTransportParameters != nil {
+					t.SentTransportParameters(tp)
+				}
+			})
+		},
+		ReceivedTransportParameters: func(tp *logging.TransportParameters) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.ReceivedTransportParameters != nil {
+					t.ReceivedTransportParameters(tp)
+				}
+			})
+		},
+		RestoredTransportParameters: func(tp *logging.TransportParameters) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.RestoredTransportParameters != nil {
+					t.RestoredTransportParameters(tp)
+				}
+			})
+		},
+		SentLongHeaderPacket: func(hdr *logging.ExtendedHeader, size logging.ByteCount, ecn logging.ECN, ack *logging.AckFrame, frames []logging.Frame) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.SentLongHeaderPacket != nil {
+					t.SentLongHeaderPacket(hdr, size, ecn, ack, frames)
+				}
+			})
+		},
+		SentShortHeaderPacket: func(hdr *logging.ShortHeader, size logging.ByteCount, ecn logging.ECN, ack *logging.AckFrame, frames []logging.Frame) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.SentShortHeaderPacket != nil {
+					t.SentShortHeaderPacket(hdr, size, ecn, ack, frames)
+				}
+			})
+		},
+		ReceivedVersionNegotiationPacket: func(dest, src logging.ArbitraryLenConnectionID, versions []logging.VersionNumber) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.ReceivedVersionNegotiationPacket != nil {
+					t.ReceivedVersionNegotiationPacket(dest, src, versions)
+				}
+			})
+		},
+		ReceivedRetry: func(hdr *logging.Header) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.ReceivedRetry != nil {
+					t.ReceivedRetry(hdr)
+				}
+			})
+		},
+		ReceivedLongHeaderPacket: func(hdr *logging.ExtendedHeader, size logging.ByteCount, ecn logging.ECN, frames []logging.Frame) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.ReceivedLongHeaderPacket != nil {
+					t.ReceivedLongHeaderPacket(hdr, size, ecn, frames)
+				}
+			})
+		},
+		ReceivedShortHeaderPacket: func(hdr *logging.ShortHeader, size logging.ByteCount, ecn logging.ECN, frames []logging.Frame) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.ReceivedShortHeaderPacket != nil {
+					t.ReceivedShortHeaderPacket(hdr, size, ecn, frames)
+				}
+			})
+		},
+		BufferedPacket: func(pt logging.PacketType, size logging.ByteCount) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.BufferedPacket != nil {
+					t.BufferedPacket(pt, size)
+				}
+			})
+		},
+		DroppedPacket: func(pt logging.PacketType, size logging.ByteCount, reason logging.PacketDropReason) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.DroppedPacket != nil {
+					t.DroppedPacket(pt, size, reason)
+				}
+			})
+		},
+		UpdatedMetrics: func(rttStats *logging.RTTStats, cwnd, bytesInFlight logging.ByteCount, packetsInFlight int) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.UpdatedMetrics != nil {
+					t.UpdatedMetrics(rttStats, cwnd, bytesInFlight, packetsInFlight)
+				}
+			})
+		},
+		AcknowledgedPacket: func(level logging.EncryptionLevel, pn logging.PacketNumber) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.AcknowledgedPacket != nil {
+					t.AcknowledgedPacket(level, pn)
+				}
+			})
+		},
+		LostPacket: func(level logging.EncryptionLevel, pn logging.PacketNumber, reason logging.PacketLossReason) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.LostPacket != nil {
+					t.LostPacket(level, pn, reason)
+				}
+			})
+		},
+		UpdatedCongestionState: func(state logging.CongestionState) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.UpdatedCongestionState != nil {
+					t.UpdatedCongestionState(state)
+				}
+			})
+		},
+		UpdatedPTOCount: func(value uint32) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.UpdatedPTOCount != nil {
+					t.UpdatedPTOCount(value)
+				}
+			})
+		},
+		UpdatedKeyFromTLS: func(level logging.EncryptionLevel, p logging.Perspective) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.UpdatedKeyFromTLS != nil {
+					t.UpdatedKeyFromTLS(level, p)
+				}
+			})
+		},
+		UpdatedKey: func(generation logging.KeyPhase, remote bool) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.UpdatedKey != nil {
+					t.UpdatedKey(generation, remote)
+				}
+			})
+		},
+		DroppedEncryptionLevel: func(level logging.EncryptionLevel) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.DroppedEncryptionLevel != nil {
+					t.DroppedEncryptionLevel(level)
+				}
+			})
+		},
+		DroppedKey: func(generation logging.KeyPhase) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.DroppedKey != nil {
+					t.DroppedKey(generation)
+				}
+			})
+		},
+		SetLossTimer: func(tt logging.TimerType, level logging.EncryptionLevel, deadline time.Time) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.SetLossTimer != nil {
+					t.SetLossTimer(tt, level, deadline)
+				}
+			})
+		},
+		LossTimerExpired: func(tt logging.TimerType, level logging.EncryptionLevel) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.LossTimerExpired != nil {
+					t.LossTimerExpired(tt, level)
+				}
+			})
+		},
+		LossTimerCanceled: func() {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.LossTimerCanceled != nil {
+					t.LossTimerCanceled()
+				}
+			})
+		},
+		ECNStateUpdated: func(state logging.ECNState, trigger logging.ECNStateTrigger) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.ECNStateUpdated != nil {
+					t.ECNStateUpdated(state, trigger)
+				}
+			})
+		},
+		Debug: func(name, msg string) {
+			c.with(func(t *logging.ConnectionTracer) {
+				if t.Debug != nil {
+					t.Debug(name, msg)
+				}
+			})
+		},
+	}
+}
+
+// liveConnInfo describes a live connection in the listing of /debug/qlog
+type liveConnInfo struct {
+	ID         string  `json:"id"`
+	Remote     string  `json:"remote,omitempty"`
+	AgeSeconds float64 `json:"age_seconds"`
+	Qlog       string  `json:"qlog,omitempty"`
+}
+
+// handler serves /debug/qlog of the admin server:
+//   - GET lists the live connections, with the qlog file of the captured ones
+//   - POST ?conn=<id> starts the capture of a connection, until it is closed
+//   - DELETE ?conn=<id> stops it earlier
+func (c *qlogCaptures) handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		c.mutex.Lock()
+		conns := make([]*liveConn, 0, len(c.conns))
+		for _, conn := range c.conns {
+			conns = append(conns, conn)
+		}
+		c.mutex.Unlock()
+		sort.Slice(conns, func(i, j int) bool { return conns[i].opened.Before(conns[j].opened) })
+		infos := make([]liveConnInfo, 0, len(conns))
+		for _, conn := range conns {
+			conn.mutex.RLock()
+			info := liveConnInfo{ID: conn.id.String(), AgeSeconds: time.Since(conn.opened).Seconds()}
+			if conn.remote != nil {
+				info.Remote = conn.remote.String()
+			}
+			if conn.qlog != nil {
+				info.Qlog = conn.filename
+			}
+			conn.mutex.RUnlock()
+			infos = append(infos, info)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+		return
+	}
+
+	conn := c.get(r.URL.Query().Get("conn"))
+	if conn == nil {
+		http.Error(w, "unknown connection", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		filename, err := conn.start()
+		if err != nil {
+			log.Errorf("Unable to capture the qlog of %s: %v", conn.id, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, filename)
+	case http.MethodDelete:
+		conn.stop()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// captureOnHeader starts the qlog capture of the connections sending a request with the X-Qlog-Capture header,
+// while the log level is debug, and the connections are registered (see qlogCaptures.enabled). The
+// response carries the connection ID of the capture in the same header.
+func (c *qlogCaptures) captureOnHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Header[qlogCaptureHeader]; ok && log.DebugEnabled() {
+			if h, ok := w.(http3.Hijacker); ok {
+				if qconn, ok := h.StreamCreator().(quic.Connection); ok {
+					tracingID, _ := qconn.Context().Value(quic.ConnectionTracingKey).(uint64)
+					c.mutex.Lock()
+					conn := c.byTracingID[tracingID]
+					c.mutex.Unlock()
+					if conn != nil {
+						if _, err := conn.start(); err != nil {
+							loggerFrom(r.Context()).Errorf("Unable to capture the qlog of %s: %v", conn.id, err)
+						} else {
+							w.Header().Set(qlogCaptureHeader, conn.id.String())
+						}
+					}
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// urlRule is one line of the -url-rules file:
+//
+//	rewrite <regexp> <replacement>
+//	redirect <301|302|307|308> <regexp> <target>
+//
+// The regexp is matched against the path, the replacement and the target can refer to its groups as $1.
+type urlRule struct {
+	pattern  *regexp.Regexp
+	template string
+	redirect int // status of the redirect, 0 for a rewrite
+}
+
+// urlRules rewrites and redirects the requests before they are routed, the rules being applied in
+// order: a rewrite changes the path seen by the next rules, the first redirect matching ends them
+type urlRules []urlRule
+
+// loadURLRules reads the rules of the file at path, the empty lines and those starting with # skipped
+func loadURLRules(path string) (urlRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules urlRules
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		rule := urlRule{}
+		switch {
+		case fields[0] == "rewrite" && len(fields) == 3:
+		case fields[0] == "redirect" && len(fields) == 4:
+			switch rule.redirect, _ = strconv.Atoi(fields[1]); rule.redirect {
+			case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			default:
+				return nil, fmt.Errorf("%s:%d: invalid redirect status %s, expected 301, 302, 307 or 308", path, n, fields[1])
+			}
+			fields = fields[1:]
+		default:
+			return nil, fmt.Errorf("%s:%d: expected rewrite <regexp> <replacement> or redirect <status> <regexp> <target>", path, n)
+		}
+		if rule.pattern, err = regexp.Compile(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		rule.template = fields[2]
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// expand returns the replacement of the rule for path, and whether the rule matches it
+func (rule *urlRule) expand(path string) (string, bool) {
+	match := rule.pattern.FindStringSubmatchIndex(path)
+	if match == nil {
+		return "", false
+	}
+	return string(rule.pattern.ExpandString(nil, rule.template, path, match)), true
+}
+
+// withQuery appends the query of the request to target, after the one of target if any
+func withQuery(target, query string) string {
+	if len(query) == 0 {
+		return target
+	}
+	if strings.Contains(target, "?") {
+		return target + "&" + query
+	}
+	return target + "?" + query
+}
+
+func (rules urlRules) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, query := r.URL.Path, r.URL.RawQuery
+		for i := range rules {
+			rule := &rules[i]
+			target, ok := rule.expand(path)
+			if !ok {
+				continue
+			}
+			if rule.redirect != 0 {
+				loggerFrom(r.Context()).Debugf("Redirecting %s to %s", r.URL.Path, target)
+				http.Redirect(w, r, withQuery(target, query), rule.redirect)
+				return
+			}
+			path, query, _ = strings.Cut(withQuery(target, query), "?")
+		}
+		if path == r.URL.Path && query == r.URL.RawQuery {
+			next.ServeHTTP(w, r)
+			return
+		}
+		loggerFrom(r.Context()).Debugf("Rewriting %s to %s", r.URL.RequestURI(), withQuery(path, query))
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path, r2.URL.RawPath, r2.URL.RawQuery = path, "", query
+		r2.RequestURI = r2.URL.RequestURI()
+		next.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,346 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+)
+
+// quicV2 is the version number of QUIC version 2, whose long header packet types differ from version 1
+const quicV2 = 0x6b3343cf
+
+// handoverMarker is sent by the previous process once it stopped reading the UDP socket, and again when it exits
+var handoverMarker = []byte{0}
+
+// routedConn routes the packets of a UDP socket shared with the other processes of a binary upgrade
+// by the generation in the first byte of their connection ID. The packets of the connections of the
+// other generations are forwarded to the previous process, on a unix socket, while it drains them
+// and until it exits. Once this process hands the socket over to its successor, it reads the packets
+// forwarded by the successor instead.
+//
+// It reads the socket in batches as quic-go does, keeping the ECN and packet info control
+// messages, which are forwarded with the packets.
+type routedConn struct {
+	*net.UDPConn
+	batch *ipv4.PacketConn
+
+	// predecessor receives the packets of the previous process, nil without one
+	predecessor *net.UnixConn
+	awaited     bool          // released waited for
+	released    chan struct{} // closed once the previous process stopped reading the UDP socket
+	gone        chan struct{} // closed once the previous process exited
+	goneOnce    sync.Once
+	forwarding  atomic.Bool
+
+	// successor sends the packets of the connections of this process once it is handed over
+	successor  atomic.Pointer[net.UnixConn]
+	handedOver atomic.Bool
+	mutex      sync.Mutex
+	switched   bool // first handoverMarker sent
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	readBuf, writeBuf []byte
+}
+
+// routePackets routes the packets of conn, forwarding the ones of the previous process on link
+// when it was started by a binary upgrade
+func routePackets(conn net.PacketConn, link *os.File) net.PacketConn {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		if link != nil {
+			link.Close()
+		}
+		return conn
+	}
+	c := &routedConn{UDPConn: udpConn, batch: ipv4.NewPacketConn(udpConn), closed: make(chan struct{}), readBuf: make([]byte, 1<<16)}
+	if link != nil {
+		defer link.Close()
+		lc, err := net.FileConn(link)
+		if err != nil {
+			log.Warnf("Unable to forward the packets of the previous process on %s: %v", conn.LocalAddr(), err)
+			return c
+		}
+		c.predecessor = lc.(*net.UnixConn)
+		c.released, c.gone = make(chan struct{}), make(chan struct{})
+		c.forwarding.Store(true)
+		go c.watchPredecessor()
+	}
+	return c
+}
+
+// watchPredecessor reads the markers sent by the previous process
+func (c *routedConn) watchPredecessor() {
+	b := make([]byte, len(handoverMarker))
+	_, err := c.predecessor.Read(b)
+	close(c.released)
+	if err == nil {
+		_, err = c.predecessor.Read(b)
+	}
+	if err != nil && errors.Is(err, net.ErrClosed) {
+		return
+	}
+	c.predecessorGone(err)
+}
+
+func (c *routedConn) predecessorGone(err error) {
+	c.goneOnce.Do(func() {
+		c.forwarding.Store(false)
+		close(c.gone)
+		if err != nil {
+			log.Infof("Previous process gone from %s (%v), its packets are no longer forwarded", c.LocalAddr(), err)
+		} else {
+			log.Infof("Previous process gone from %s, its packets are no longer forwarded", c.LocalAddr())
+		}
+	})
+}
+
+// waitRelayed waits until the previous process exited, or until ctx is done, the packets of its
+// connections being forwarded by this process until then
+func waitRelayed(ctx context.Context, conn net.PacketConn) {
+	if c, ok := conn.(*routedConn); ok && c.predecessor != nil {
+		select {
+		case <-c.gone:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// prepareHandover creates the link on which the new process forwards the packets of the
+// connections of this one, returning the end of the new process
+func prepareHandover(conn net.PacketConn) (*os.File, error) {
+	c, ok := conn.(*routedConn)
+	if !ok {
+		return nil, nil
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	f := os.NewFile(uintptr(fds[0]), "route")
+	defer f.Close()
+	lc, err := net.FileConn(f)
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, err
+	}
+	if old := c.successor.Swap(lc.(*net.UnixConn)); old != nil {
+		old.Close()
+	}
+	return os.NewFile(uintptr(fds[1]), "route"), nil
+}
+
+func cancelHandover(conn net.PacketConn) {
+	if c, ok := conn.(*routedConn); ok && !c.handedOver.Load() {
+		if s := c.successor.Swap(nil); s != nil {
+			s.Close()
+		}
+	}
+}
+
+// handOver stops reading the UDP socket, to read the packets forwarded by the successor
+func handOver(conn net.PacketConn) {
+	if c, ok := conn.(*routedConn); ok && c.successor.Load() != nil {
+		c.handedOver.Store(true)
+		// interrupts the read in progress
+		c.UDPConn.SetReadDeadline(time.Now())
+	}
+}
+
+// packetGeneration returns the generation in the connection ID of packet b, false for the packets
+// starting a connection, whose connection ID was chosen by the client, and the invalid ones
+func packetGeneration(b []byte) (byte, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	if b[0]&0x80 == 0 {
+		// short header, followed by the connection ID
+		if len(b) < 1+connIDLen {
+			return 0, false
+		}
+		return b[1], true
+	}
+	// long header: only the Handshake packets of the client carry a connection ID of the server
+	if len(b) < 7 || b[5] != connIDLen {
+		return 0, false
+	}
+	packetType := b[0] >> 4 & 0x3
+	switch binary.BigEndian.Uint32(b[1:5]) {
+	case 1:
+		return b[6], packetType == 2
+	case quicV2:
+		return b[6], packetType == 3
+	}
+	return 0, false
+}
+
+// route returns whether the packet of m is served by this process, forwarding it to the previous one otherwise
+func (c *routedConn) route(m *ipv4.Message) bool {
+	generation, ok := packetGeneration(m.Buffers[0][:m.N])
+	if !ok || generation == upgradeGeneration || !c.forwarding.Load() {
+		return true
+	}
+	addr, ok := m.Addr.(*net.UDPAddr)
+	if !ok {
+		return true
+	}
+	a, err := addr.AddrPort().MarshalBinary()
+	if err != nil {
+		return true
+	}
+	c.writeBuf = append(c.writeBuf[:0], byte(len(a)))
+	c.writeBuf = append(c.writeBuf, a...)
+	c.writeBuf = binary.BigEndian.AppendUint16(c.writeBuf, uint16(m.NN))
+	c.writeBuf = append(c.writeBuf, m.OOB[:m.NN]...)
+	c.writeBuf = append(c.writeBuf, m.Buffers[0][:m.N]...)
+
+	rawConn, err := c.predecessor.SyscallConn()
+	if err == nil {
+		// the packet is dropped when the previous process does not keep up
+		rawConn.Write(func(fd uintptr) bool {
+			err = unix.Sendto(int(fd), c.writeBuf, unix.MSG_DONTWAIT, nil)
+			return true
+		})
+	}
+	if err == nil || errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.ENOBUFS) {
+		return false
+	}
+	c.predecessorGone(err)
+	return true
+}
+
+// release tells the successor it can read the UDP socket
+func (c *routedConn) release(s *net.UnixConn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.switched {
+		c.switched = true
+		if _, err := s.Write(handoverMarker); err != nil {
+			log.Warnf("Unable to hand %s over to the new process: %v", c.LocalAddr(), err)
+		}
+	}
+}
+
+// readForwarded reads a packet forwarded by the successor into ms[0]
+func (c *routedConn) readForwarded(ms []ipv4.Message) (int, error) {
+	s := c.successor.Load()
+	c.release(s)
+	for {
+		n, err := s.Read(c.readBuf)
+		if err != nil {
+			return 0, err
+		}
+		b := c.readBuf[:n]
+		if len(b) < 1 || len(b) < 1+int(b[0])+2 {
+			continue
+		}
+		var addr netip.AddrPort
+		if addr.UnmarshalBinary(b[1:1+b[0]]) != nil {
+			continue
+		}
+		b = b[1+b[0]:]
+		oobLen := int(binary.BigEndian.Uint16(b))
+		b = b[2:]
+		if len(b) < oobLen {
+			continue
+		}
+		m := &ms[0]
+		m.Addr = net.UDPAddrFromAddrPort(addr)
+		m.NN = copy(m.OOB, b[:oobLen])
+		m.N = copy(m.Buffers[0], b[oobLen:])
+		return 1, nil
+	}
+}
+
+// ReadBatch reads the packets served by this process into the first messages of ms, skipping the packets forwarded
+func (c *routedConn) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	if c.predecessor != nil && !c.awaited {
+		// the previous process reads the socket until it is done, and kills this one after upgradeTimeout
+		c.awaited = true
+		select {
+		case <-c.released:
+		case <-c.closed:
+			return 0, net.ErrClosed
+		case <-time.After(upgradeTimeout):
+			log.Warnf("No handover of %s by the previous process after %v", c.LocalAddr(), upgradeTimeout)
+		}
+	}
+	for {
+		var n int
+		var err error
+		if c.handedOver.Load() {
+			n, err = c.readForwarded(ms)
+		} else if n, err = c.batch.ReadBatch(ms, flags); err != nil && c.handedOver.Load() && errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		served := 0
+		for i := range ms[:n] {
+			if !c.route(&ms[i]) {
+				continue
+			}
+			if served < i {
+				// copied as quic-go ties the buffers to the indexes of the messages
+				m := &ms[served]
+				m.N = copy(m.Buffers[0], ms[i].Buffers[0][:ms[i].N])
+				m.NN = copy(m.OOB, ms[i].OOB[:ms[i].NN])
+				m.Addr, m.Flags = ms[i].Addr, ms[i].Flags
+			}
+			served++
+		}
+		if served > 0 {
+			return served, nil
+		}
+	}
+}
+
+// ReadFrom is used instead of ReadBatch by quic-go on the platforms without control messages
+func (c *routedConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	ms := []ipv4.Message{{Buffers: [][]byte{p}}}
+	if _, err := c.ReadBatch(ms, 0); err != nil {
+		return 0, nil, err
+	}
+	return ms[0].N, ms[0].Addr, nil
+}
+
+// SetReadDeadline applies to the successor once handed over, quic-go setting it to interrupt the reads when it closes
+func (c *routedConn) SetReadDeadline(t time.Time) error {
+	if c.handedOver.Load() {
+		// the UDP socket stays interrupted
+		return c.successor.Load().SetReadDeadline(t)
+	}
+	return c.UDPConn.SetReadDeadline(t)
+}
+
+// Close tells the successor this process exits, for it to stop forwarding the packets
+func (c *routedConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	if c.predecessor != nil {
+		c.predecessor.Close()
+	}
+	if s := c.successor.Load(); s != nil {
+		if c.handedOver.Load() {
+			c.release(s)
+			s.Write(handoverMarker)
+		}
+		s.Close()
+	}
+	return c.UDPConn.Close()
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// socksALPN is the ALPN of the SOCKS5 gateway: every bidirectional stream carries one SOCKS5 session (RFC 1928)
+const socksALPN = "quicgo-socks5"
+
+const socksDialTimeout = 10 * time.Second
+
+// SOCKS5 constants (RFC 1928 and RFC 1929)
+const (
+	socksVersion        = 5
+	socksMethodNoAuth   = 0x00
+	socksMethodPassword = 0x02
+	socksNoMethod       = 0xff
+	socksCmdConnect     = 0x01
+	socksAddrIPv4       = 0x01
+	socksAddrDomain     = 0x03
+	socksAddrIPv6       = 0x04
+
+	socksSucceeded           = 0x00
+	socksGeneralFailure      = 0x01
+	socksNotAllowed          = 0x02
+	socksHostUnreachable     = 0x04
+	socksConnectionRefused   = 0x05
+	socksCmdNotSupported     = 0x07
+	socksAddrTypeUnsupported = 0x08
+)
+
+var errSOCKSNotAllowed = errors.New("destination not allowed")
+
+// parseSOCKSAllow parses the comma separated networks of -socks-allow, an address standing for itself
+func parseSOCKSAllow(v string) ([]netip.Prefix, error) {
+	var allowed []netip.Prefix
+	for _, network := range strings.Split(v, ",") {
+		if network = strings.TrimSpace(network); len(network) == 0 {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(network)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid network %s in -socks-allow: %w", network, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		allowed = append(allowed, prefix.Masked())
+	}
+	return allowed, nil
+}
+
+// checkSOCKSDestination denies the loopback, link-local, private and multicast destinations, and the
+// addresses of the server itself, unless they are in allowed. It is called with the resolved address
+// to connect to, the domains resolving to internal addresses being denied as well.
+func checkSOCKSDestination(address string, allowed []netip.Prefix) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap().WithZone("")
+	for _, prefix := range allowed {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	if addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsMulticast() {
+		return errSOCKSNotAllowed
+	}
+	local, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+	for _, a := range local {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(addr.AsSlice()) {
+			return errSOCKSNotAllowed
+		}
+	}
+	return nil
+}
+
+// serveSOCKS relays the SOCKS5 sessions of the connections of ln to TCP targets, the clients
+// authenticating with creds ("user:password"). The internal destinations are denied unless allowed.
+func serveSOCKS(ln http3.QUICEarlyListener, creds string, allowed []netip.Prefix) error {
+	for {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return err
+		}
+		go func() {
+			for {
+				str, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					log.Debugf("Accepting SOCKS stream failed: %v", err)
+					return
+				}
+				go handleSOCKSStream(conn, str, creds, allowed)
+			}
+		}()
+	}
+}
+
+func handleSOCKSStream(conn quic.Connection, str quic.Stream, creds string, allowed []netip.Prefix) {
+	defer str.Close()
+	target, err := socksHandshake(str, creds)
+	if err != nil {
+		log.Debugf("SOCKS session of %s failed: %v", conn.RemoteAddr(), err)
+		str.CancelRead(0)
+		return
+	}
+
+	dialer := &net.Dialer{
+		Timeout: socksDialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkSOCKSDestination(address, allowed)
+		},
+	}
+	tcpConn, err := dialer.Dial("tcp", target)
+	if err != nil {
+		log.Debugf("SOCKS connection of %s to %s failed: %v", conn.RemoteAddr(), target, err)
+		reply := byte(socksHostUnreachable)
+		var opErr *net.OpError
+		if errors.Is(err, errSOCKSNotAllowed) {
+			reply = socksNotAllowed
+		} else if errors.As(err, &opErr) && strings.Contains(opErr.Err.Error(), "refused") {
+			reply = socksConnectionRefused
+		}
+		writeSOCKSReply(str, reply, nil)
+		return
+	}
+	defer tcpConn.Close()
+	log.Infof("SOCKS %s connected to %s", conn.RemoteAddr(), target)
+	if err := writeSOCKSReply(str, socksSucceeded, tcpConn.LocalAddr().(*net.TCPAddr)); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(tcpConn, str)
+		tcpConn.(*net.TCPConn).CloseWrite()
+		close(done)
+	}()
+	io.Copy(str, tcpConn)
+	// stop sending, the target may still be reading what the client sent
+	str.Close()
+	<-done
+}
+
+// socksHandshake negotiates the authentication and reads the CONNECT request, it returns the target address
+func socksHandshake(rw io.ReadWriter, creds string) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(rw, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(rw, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksMethodNoAuth)
+	if len(creds) > 0 {
+		method = socksMethodPassword
+	}
+	if !strings.Contains(string(methods), string([]byte{method})) {
+		rw.Write([]byte{socksVersion, socksNoMethod})
+		return "", errors.New("no acceptable authentication method")
+	}
+	if _, err := rw.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksMethodPassword {
+		if err := socksAuthenticate(rw, creds); err != nil {
+			return "", err
+		}
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(rw, req); err != nil {
+		return "", err
+	}
+	if req[1] != socksCmdConnect {
+		writeSOCKSReply(rw, socksCmdNotSupported, nil)
+		return "", fmt.Errorf("unsupported SOCKS command %d", req[1])
+	}
+	var host string
+	switch req[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if req[3] == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(rw, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(rw, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(rw, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		writeSOCKSReply(rw, socksAddrTypeUnsupported, nil)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", req[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(rw, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksAuthenticate checks the username/password sub-negotiation (RFC 1929)
+func socksAuthenticate(rw io.ReadWriter, creds string) error {
+	expectedUser, expectedPassword, _ := strings.Cut(creds, ":")
+	readField := func() ([]byte, error) {
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(rw, length); err != nil {
+			return nil, err
+		}
+		field := make([]byte, length[0])
+		_, err := io.ReadFull(rw, field)
+		return field, err
+	}
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(rw, version); err != nil {
+		return err
+	}
+	user, err := readField()
+	if err != nil {
+		return err
+	}
+	password, err := readField()
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(user, []byte(expectedUser)) != 1 ||
+		subtle.ConstantTimeCompare(password, []byte(expectedPassword)) != 1 {
+		rw.Write([]byte{1, 1})
+		return errors.New("invalid SOCKS credentials")
+	}
+	_, err = rw.Write([]byte{1, 0})
+	return err
+}
+
+// writeSOCKSReply answers a request, with the local address of the TCP connection when it succeeded
+func writeSOCKSReply(w io.Writer, reply byte, bound *net.TCPAddr) error {
+	msg := []byte{socksVersion, reply, 0}
+	if bound == nil {
+		bound = &net.TCPAddr{IP: net.IPv4zero}
+	}
+	if ip := bound.IP.To4(); ip != nil {
+		msg = append(append(msg, socksAddrIPv4), ip...)
+	} else {
+		msg = append(append(msg, socksAddrIPv6), bound.IP.To16()...)
+	}
+	msg = binary.BigEndian.AppendUint16(msg, uint16(bound.Port))
+	_, err := w.Write(msg)
+	return err
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+var (
+	packetsSent     = newCounterVec("quicgo_packets_sent_total", "QUIC packets sent by packet type", "type")
+	packetsReceived = newCounterVec("quicgo_packets_received_total", "QUIC packets received by packet type", "type")
+	packetsLost     = newCounterVec("quicgo_packets_lost_total", "QUIC packets declared lost, their frames are retransmitted, by detection reason", "reason")
+	ptoExpirations  = newCounterVec("quicgo_pto_expirations_total", "Probe timeouts by encryption level", "level")
+	framesSent      = newCounterVec("quicgo_frames_sent_total", "QUIC frames sent by frame type", "type")
+	framesReceived  = newCounterVec("quicgo_frames_received_total", "QUIC frames received by frame type", "type")
+	keyUpdates      = newCounterVec("quicgo_key_updates_total", "1-RTT key updates by initiator", "initiator")
+)
+
+func packetTypeName(t logging.PacketType) string {
+	switch t {
+	case logging.PacketTypeInitial:
+		return "initial"
+	case logging.PacketTypeHandshake:
+		return "handshake"
+	case logging.PacketTypeRetry:
+		return "retry"
+	case logging.PacketType0RTT:
+		return "0rtt"
+	case logging.PacketTypeVersionNegotiation:
+		return "version_negotiation"
+	case logging.PacketTypeStatelessReset:
+		return "stateless_reset"
+	case logging.PacketType1RTT:
+		return "1rtt"
+	}
+	return "unknown"
+}
+
+func frameTypeName(f logging.Frame) string {
+	switch f.(type) {
+	case *logging.AckFrame:
+		return "ack"
+	case *logging.ConnectionCloseFrame:
+		return "connection_close"
+	case *logging.CryptoFrame:
+		return "crypto"
+	case *logging.DataBlockedFrame:
+		return "data_blocked"
+	case *logging.DatagramFrame:
+		return "datagram"
+	case *logging.HandshakeDoneFrame:
+		return "handshake_done"
+	case *logging.MaxDataFrame:
+		return "max_data"
+	case *logging.MaxStreamDataFrame:
+		return "max_stream_data"
+	case *logging.MaxStreamsFrame:
+		return "max_streams"
+	case *logging.NewConnectionIDFrame:
+		return "new_connection_id"
+	case *logging.NewTokenFrame:
+		return "new_token"
+	case *logging.PathChallengeFrame:
+		return "path_challenge"
+	case *logging.PathResponseFrame:
+		return "path_response"
+	case *logging.PingFrame:
+		return "ping"
+	case *logging.ResetStreamFrame:
+		return "reset_stream"
+	case *logging.RetireConnectionIDFrame:
+		return "retire_connection_id"
+	case *logging.StopSendingFrame:
+		return "stop_sending"
+	case *logging.StreamDataBlockedFrame:
+		return "stream_data_blocked"
+	case *logging.StreamFrame:
+		return "stream"
+	case *logging.StreamsBlockedFrame:
+		return "streams_blocked"
+	}
+	return "unknown"
+}
+
+// connStats holds the counters of one connection, logged when it is closed
+type connStats struct {
+	mutex          sync.Mutex
+	remote         net.Addr
+	sentPackets    uint64
+	sentBytes      logging.ByteCount
+	recvPackets    uint64
+	recvBytes      logging.ByteCount
+	lostPackets    uint64
+	ptoCount       uint64
+	keyUpdates     uint64
+	framesSent     map[string]uint64
+	framesReceived map[string]uint64
+	ecnSent        map[string]uint64 // packets by ECN codepoint
+	ecnReceived    map[string]uint64
+	ecnState       logging.ECNState
+
+	// for -conn-stats-file
+	start                                        time.Time
+	version                                      string
+	minRTT, smoothedRTT, latestRTT, rttDeviation time.Duration
+}
+
+func ecnStateName(state logging.ECNState) string {
+	switch state {
+	case logging.ECNStateTesting:
+		return "testing"
+	case logging.ECNStateUnknown:
+		return "unknown"
+	case logging.ECNStateFailed:
+		return "failed"
+	case logging.ECNStateCapable:
+		return "capable"
+	}
+	return "disabled"
+}
+
+func (s *connStats) sent(t logging.PacketType, size logging.ByteCount, ecn logging.ECN, ack *logging.AckFrame, frames []logging.Frame) {
+	packetsSent.inc(packetTypeName(t))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sentPackets++
+	s.sentBytes += size
+	s.ecnSent[ecn.String()]++
+	if ack != nil {
+		framesSent.inc("ack")
+		s.framesSent["ack"]++
+	}
+	for _, f := range frames {
+		name := frameTypeName(f)
+		framesSent.inc(name)
+		s.framesSent[name]++
+	}
+}
+
+func (s *connStats) received(t logging.PacketType, size logging.ByteCount, ecn logging.ECN, frames []logging.Frame) {
+	packetsReceived.inc(packetTypeName(t))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.recvPackets++
+	s.recvBytes += size
+	s.ecnReceived[ecn.String()]++
+	for _, f := range frames {
+		name := frameTypeName(f)
+		framesReceived.inc(name)
+		s.framesReceived[name]++
+	}
+}
+
+// newStatsTracer returns a tracer maintaining the packet and frame metrics, and logging
+// the counters of every connection when it is closed. It is much cheaper than qlog.
+func newStatsTracer() func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer {
+	return func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+		s := &connStats{
+			framesSent:     make(map[string]uint64),
+			framesReceived: make(map[string]uint64),
+			ecnSent:        make(map[string]uint64),
+			ecnReceived:    make(map[string]uint64),
+		}
+		l := loggerFrom(ctx)
+		t := &logging.ConnectionTracer{
+			StartedConnection: func(local, remote net.Addr, srcConnID, destConnID logging.ConnectionID) {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				s.remote = remote
+			},
+			SentLongHeaderPacket: func(hdr *logging.ExtendedHeader, size logging.ByteCount, ecn logging.ECN, ack *logging.AckFrame, frames []logging.Frame) {
+				s.sent(logging.PacketTypeFromHeader(&hdr.Header), size, ecn, ack, frames)
+			},
+			SentShortHeaderPacket: func(hdr *logging.ShortHeader, size logging.ByteCount, ecn logging.ECN, ack *logging.AckFrame, frames []logging.Frame) {
+				s.sent(logging.PacketType1RTT, size, ecn, ack, frames)
+			},
+			ReceivedLongHeaderPacket: func(hdr *logging.ExtendedHeader, size logging.ByteCount, ecn logging.ECN, frames []logging.Frame) {
+				s.received(logging.PacketTypeFromHeader(&hdr.Header), size, ecn, frames)
+			},
+			ReceivedShortHeaderPacket: func(hdr *logging.ShortHeader, size logging.ByteCount, ecn logging.ECN, frames []logging.Frame) {
+				s.received(logging.PacketType1RTT, size, ecn, frames)
+			},
+			LostPacket: func(_ logging.EncryptionLevel, _ logging.PacketNumber, reason logging.PacketLossReason) {
+				if reason == logging.PacketLossReorderingThreshold {
+					packetsLost.inc("reordering_threshold")
+				} else {
+					packetsLost.inc("time_threshold")
+				}
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				s.lostPackets++
+			},
+			LossTimerExpired: func(t logging.TimerType, encLevel logging.EncryptionLevel) {
+				if t != logging.TimerTypePTO {
+					return
+				}
+				ptoExpirations.inc(encLevel.String())
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				s.ptoCount++
+			},
+			UpdatedKey: func(generation logging.KeyPhase, remote bool) {
+				initiator := "local"
+				if remote {
+					initiator = "remote"
+				}
+				keyUpdates.inc(initiator)
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				s.keyUpdates++
+				l.Infof("Connection %s with %s: key phase changed to %d (%s key update)", connID, s.remote, generation, initiator)
+			},
+			ECNStateUpdated: func(state logging.ECNState, _ logging.ECNStateTrigger) {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				s.ecnState = state
+			},
+			ClosedConnection: func(err error) {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				l.Infof("Connection %s with %s closed: sent %d packets (%d bytes), received %d packets (%d bytes), lost %d packets, %d PTOs, %d key updates, frames sent %v, frames received %v, ECN %s, ECN sent %v, ECN received %v",
+					connID, s.remote, s.sentPackets, s.sentBytes, s.recvPackets, s.recvBytes, s.lostPackets, s.ptoCount, s.keyUpdates, s.framesSent, s.framesReceived,
+					ecnStateName(s.ecnState), s.ecnSent, s.ecnReceived)
+				if connStatsExport != nil {
+					connStatsExport.write(connID.String(), s, err)
+				}
+			},
+		}
+		if connStatsExport != nil {
+			s.start = time.Now()
+			t.NegotiatedVersion = func(chosen logging.VersionNumber, _, _ []logging.VersionNumber) {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				s.version = chosen.String()
+			}
+			t.UpdatedMetrics = func(rttStats *logging.RTTStats, _, _ logging.ByteCount, _ int) {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				s.minRTT, s.smoothedRTT, s.latestRTT, s.rttDeviation = rttStats.MinRTT(), rttStats.SmoothedRTT(), rttStats.LatestRTT(), rttStats.MeanDeviation()
+			}
+		}
+		return t
+	}
+}
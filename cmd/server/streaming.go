@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// fileStreamer sends the static files in chunks of chunkSize bytes taken from a pool, reading the
+// next chunk from the disk while the previous one is sent, instead of the 32KB copies of io.Copy
+type fileStreamer struct {
+	chunkSize int
+	pool      sync.Pool
+}
+
+func newFileStreamer(chunkSize int) *fileStreamer {
+	s := &fileStreamer{chunkSize: chunkSize}
+	s.pool.New = func() any {
+		buf := make([]byte, chunkSize)
+		return &buf
+	}
+	return s
+}
+
+// handler serves the files of next, an http.FileServer, which copies them to the ReaderFrom of the response writer
+func (s *fileStreamer) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&streamingWriter{ResponseWriter: w, streamer: s}, r)
+	})
+}
+
+// streamingWriter is the response writer given to the file server
+type streamingWriter struct {
+	http.ResponseWriter
+	streamer *fileStreamer
+}
+
+// chunk is a part of the file read ahead, with the error which ended the read
+type chunk struct {
+	buf *[]byte
+	n   int
+	err error
+}
+
+func (w *streamingWriter) ReadFrom(src io.Reader) (int64, error) {
+	pool := &w.streamer.pool
+	// one chunk is read while the previous one is written
+	chunks := make(chan chunk, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(chunks)
+		for {
+			buf := pool.Get().(*[]byte)
+			n, err := io.ReadFull(src, *buf)
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = io.EOF
+			}
+			select {
+			case chunks <- chunk{buf: buf, n: n, err: err}:
+			case <-done:
+				pool.Put(buf)
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	// the reader must be done with src when returning, the file server closes it
+	defer func() {
+		close(done)
+		for c := range chunks {
+			pool.Put(c.buf)
+		}
+	}()
+
+	var written int64
+	for c := range chunks {
+		n, err := w.ResponseWriter.Write((*c.buf)[:c.n])
+		written += int64(n)
+		pool.Put(c.buf)
+		if err != nil {
+			return written, err
+		}
+		if errors.Is(c.err, io.EOF) {
+			return written, nil
+		}
+		if c.err != nil {
+			return written, c.err
+		}
+	}
+	return written, nil
+}
+
+func (w *streamingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *streamingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
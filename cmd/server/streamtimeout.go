@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// h3FrameTypeHeaders is the type of the HEADERS frame starting the request streams
+const h3FrameTypeHeaders = 0x1
+
+var streamTimeouts = newCounterVec("quicgo_stream_timeouts_total", "HTTP/3 request streams reset by -header-timeout and -stream-idle-timeout, by reason", "reason")
+
+// streamTimeoutListener resets the request streams of its connections whose headers are not received
+// within headerTimeout, with H3_REQUEST_REJECTED as the request was not processed, or without any data
+// sent or received for idleTimeout, with H3_REQUEST_CANCELLED. 0 disables a timeout.
+type streamTimeoutListener struct {
+	http3.QUICEarlyListener
+	headerTimeout, idleTimeout time.Duration
+}
+
+func (l *streamTimeoutListener) Accept(ctx context.Context) (quic.EarlyConnection, error) {
+	conn, err := l.QUICEarlyListener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &streamTimeoutConn{EarlyConnection: conn, listener: l}, nil
+}
+
+type streamTimeoutConn struct {
+	quic.EarlyConnection
+	listener *streamTimeoutListener
+}
+
+func (c *streamTimeoutConn) AcceptStream(ctx context.Context) (quic.Stream, error) {
+	str, err := c.EarlyConnection.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &streamTimeoutStream{Stream: str, conn: c.EarlyConnection, idleTimeout: c.listener.idleTimeout, left: -1}
+	if c.listener.headerTimeout > 0 {
+		s.headers = time.AfterFunc(c.listener.headerTimeout, func() { s.reset("headers", http3.ErrCodeRequestRejected) })
+	}
+	if s.idleTimeout > 0 {
+		s.idle = time.AfterFunc(s.idleTimeout, func() { s.reset("idle", http3.ErrCodeRequestCanceled) })
+	}
+	return s, nil
+}
+
+type streamTimeoutStream struct {
+	quic.Stream
+	conn        quic.Connection
+	idleTimeout time.Duration
+
+	mutex   sync.Mutex
+	headers *time.Timer // nil once the HEADERS frame is received
+	idle    *time.Timer
+	prefix  []byte // start of the stream until the length of the HEADERS frame is known
+	left    int64  // bytes of the HEADERS frame not received yet, -1 until its length is known
+	done    bool
+}
+
+// received follows the HEADERS frame in the bytes read from the stream, and stops the header timer once it is complete
+func (s *streamTimeoutStream) received(b []byte) {
+	if s.headers == nil {
+		return
+	}
+	if s.left < 0 {
+		s.prefix = append(s.prefix, b...)
+		frameType, n, ok := varint(s.prefix)
+		if !ok {
+			return
+		}
+		length, m, ok := varint(s.prefix[n:])
+		if !ok {
+			return
+		}
+		if frameType != h3FrameTypeHeaders {
+			// the server rejects the stream itself
+			s.headers.Stop()
+			s.headers, s.prefix = nil, nil
+			return
+		}
+		s.left = int64(length) - int64(len(s.prefix)-n-m)
+		s.prefix = nil
+	} else {
+		s.left -= int64(len(b))
+	}
+	if s.left <= 0 {
+		s.headers.Stop()
+		s.headers = nil
+	}
+}
+
+func (s *streamTimeoutStream) active() {
+	if s.idle != nil && !s.done {
+		s.idle.Reset(s.idleTimeout)
+	}
+}
+
+func (s *streamTimeoutStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	if n > 0 {
+		s.mutex.Lock()
+		s.received(p[:n])
+		s.active()
+		s.mutex.Unlock()
+	}
+	return n, err
+}
+
+func (s *streamTimeoutStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	if n > 0 {
+		s.mutex.Lock()
+		s.active()
+		s.mutex.Unlock()
+	}
+	return n, err
+}
+
+// stop stops the timers once the response is complete or the stream reset
+func (s *streamTimeoutStream) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.done = true
+	if s.headers != nil {
+		s.headers.Stop()
+	}
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+}
+
+func (s *streamTimeoutStream) Close() error {
+	s.stop()
+	return s.Stream.Close()
+}
+
+func (s *streamTimeoutStream) CancelWrite(code quic.StreamErrorCode) {
+	s.stop()
+	s.Stream.CancelWrite(code)
+}
+
+// reset resets both directions of the stream with code when a timeout expires
+func (s *streamTimeoutStream) reset(reason string, code http3.ErrCode) {
+	s.mutex.Lock()
+	if s.done {
+		s.mutex.Unlock()
+		return
+	}
+	s.done = true
+	s.mutex.Unlock()
+	streamTimeouts.inc(reason)
+	connLogger(s.conn).Infof("Resetting stream %d after the %s timeout", s.StreamID(), reason)
+	s.Stream.CancelRead(quic.StreamErrorCode(code))
+	s.Stream.CancelWrite(quic.StreamErrorCode(code))
+}
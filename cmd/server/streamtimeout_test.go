@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamTimeoutHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		after  int // chunk after which the header timer is stopped, -1 if never
+	}{
+		{"one read", [][]byte{{0x01, 0x03, 'a', 'b', 'c', 0x00}}, 0},
+		{"frame split", [][]byte{{0x01}, {0x03, 'a'}, {'b'}, {'c'}}, 3},
+		{"two bytes length split", [][]byte{{0x01, 0x40}, {0x02}, {'a'}, {'b'}}, 3},
+		{"long frame", [][]byte{{0x01, 0x40, 0x64}, make([]byte, 60), make([]byte, 40)}, 2},
+		{"empty frame", [][]byte{{0x01, 0x00}}, 0},
+		{"incomplete", [][]byte{{0x01, 0x05, 'a'}, {'b'}}, -1},
+		{"not headers", [][]byte{{0x00, 0x05}}, 0},
+		{"two bytes type", [][]byte{{0x40}, {0x01, 0x01}, {'a'}}, 2},
+	}
+	for _, test := range tests {
+		s := &streamTimeoutStream{headers: time.AfterFunc(time.Hour, func() {}), left: -1}
+		for i, chunk := range test.chunks {
+			s.received(chunk)
+			if stopped := s.headers == nil; stopped != (test.after >= 0 && i >= test.after) {
+				t.Errorf("%s: header timer stopped %v after chunk %d", test.name, stopped, i)
+			}
+		}
+		if s.headers != nil {
+			s.headers.Stop()
+		}
+	}
+}
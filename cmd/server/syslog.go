@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogSDID is the ID of the structured data element carrying the fields of the log entries,
+// under the enterprise number reserved for documentation (RFC 5612)
+const syslogSDID = "quicgo@32473"
+
+const (
+	syslogQueueSize   = 1024
+	syslogDialTimeout = 5 * time.Second
+	// syslogFlushTimeout bounds the wait for the queued messages before a fatal exit
+	syslogFlushTimeout = 2 * time.Second
+)
+
+// the RFC 5424 severities of the messages
+const (
+	syslogCritical = 2
+	syslogError    = 3
+	syslogWarning  = 4
+	syslogInfo     = 6
+	syslogDebug    = 7
+)
+
+var syslogDropped = newCounterVec("quicgo_syslog_dropped_messages_total", "Log messages not sent to the remote syslog by reason", "reason")
+
+// syslogFacilities are the facility codes of RFC 5424, by name
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogWriter sends the messages to a remote syslog in the RFC 5424 format, from a queue so that a slow
+// or unreachable collector never blocks the server: the messages are dropped when the queue is full
+type syslogWriter struct {
+	network, addr string
+	facility      int
+	hostname      string
+	appName       string
+	queue         chan []byte
+	pending       sync.WaitGroup
+	conn          net.Conn // only used by the sending goroutine
+}
+
+// newSyslogWriter creates the writer of -log-syslog. addr is "host:port" (UDP), "udp:host:port",
+// "tcp:host:port" or "unix:/dev/log".
+func newSyslogWriter(addr, facility string) (*syslogWriter, error) {
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %s", facility)
+	}
+	s := &syslogWriter{network: "udp", addr: addr, facility: code, appName: filepath.Base(os.Args[0]), queue: make(chan []byte, syslogQueueSize)}
+	if network, rest, ok := strings.Cut(addr, ":"); ok {
+		switch network {
+		case "udp", "tcp", "unix":
+			s.network, s.addr = network, rest
+		}
+	}
+	if s.hostname, _ = os.Hostname(); len(s.hostname) == 0 {
+		s.hostname = "-"
+	}
+	// fail at startup rather than on the first message when the address is wrong
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	go s.send()
+	return s, nil
+}
+
+// log queues a message, waiting for it to be sent when it is critical: the server exits right after
+func (s *syslogWriter) log(severity int, msg string, fields map[string]any) {
+	s.pending.Add(1)
+	select {
+	case s.queue <- s.format(severity, msg, fields):
+	default:
+		s.pending.Done()
+		syslogDropped.inc("queue_full")
+	}
+	if severity <= syslogCritical {
+		done := make(chan struct{})
+		go func() {
+			s.pending.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(syslogFlushTimeout):
+		}
+	}
+}
+
+// format writes an RFC 5424 message, its fields such as conn_id in the structured data
+func (s *syslogWriter) format(severity int, msg string, fields map[string]any) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - ", s.facility*8+severity,
+		time.Now().UTC().Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, s.appName, os.Getpid())
+	if len(fields) == 0 {
+		b.WriteString("-")
+	} else {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString("[" + syslogSDID)
+		for _, k := range keys {
+			fmt.Fprintf(&b, ` %s="%s"`, syslogParamName(k), syslogParamValue(fmt.Sprint(fields[k])))
+		}
+		b.WriteString("]")
+	}
+	b.WriteString(" " + msg)
+	return []byte(b.String())
+}
+
+// syslogParamName removes from a field name the characters a PARAM-NAME can't hold
+func syslogParamName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+// syslogParamValue escapes the characters of a PARAM-VALUE which would end it
+func syslogParamValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
+
+func (s *syslogWriter) dial() error {
+	if s.network != "unix" {
+		conn, err := net.DialTimeout(s.network, s.addr, syslogDialTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		return nil
+	}
+	// the local syslog daemons listen on datagram sockets, some on stream ones
+	conn, err := net.DialTimeout("unixgram", s.addr, syslogDialTimeout)
+	if err != nil {
+		if conn, err = net.DialTimeout("unix", s.addr, syslogDialTimeout); err != nil {
+			return err
+		}
+	}
+	s.conn = conn
+	return nil
+}
+
+// send writes the queued messages, dialing again once the connection is lost
+func (s *syslogWriter) send() {
+	for msg := range s.queue {
+		if err := s.write(msg); err != nil {
+			syslogDropped.inc("write_error")
+			fmt.Fprintf(os.Stderr, "Unable to send a log message to the syslog %s: %v\n", s.addr, err)
+		}
+		s.pending.Done()
+	}
+}
+
+func (s *syslogWriter) write(msg []byte) error {
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			return err
+		}
+	}
+	switch s.conn.LocalAddr().Network() {
+	case "tcp":
+		// octet counting framing (RFC 6587)
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	case "unix":
+		msg = append(msg, '\n')
+	}
+	if _, err := s.conn.Write(msg); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// syslogLogger writes the messages of a logger to the syslog as well, whatever its backend
+type syslogLogger struct {
+	logger
+	syslog *syslogWriter
+	fields map[string]any
+}
+
+func (l syslogLogger) Debugf(format string, args ...any) {
+	l.logger.Debugf(format, args...)
+	if l.DebugEnabled() {
+		l.syslog.log(syslogDebug, fmt.Sprintf(format, args...), l.fields)
+	}
+}
+
+func (l syslogLogger) Infof(format string, args ...any) {
+	l.logger.Infof(format, args...)
+	l.syslog.log(syslogInfo, fmt.Sprintf(format, args...), l.fields)
+}
+
+func (l syslogLogger) Warnf(format string, args ...any) {
+	l.logger.Warnf(format, args...)
+	l.syslog.log(syslogWarning, fmt.Sprintf(format, args...), l.fields)
+}
+
+func (l syslogLogger) Errorf(format string, args ...any) {
+	l.logger.Errorf(format, args...)
+	l.syslog.log(syslogError, fmt.Sprintf(format, args...), l.fields)
+}
+
+func (l syslogLogger) Fatalf(format string, args ...any) {
+	l.syslog.log(syslogCritical, fmt.Sprintf(format, args...), l.fields)
+	l.logger.Fatalf(format, args...)
+}
+
+func (l syslogLogger) Info(args ...any) {
+	l.logger.Info(args...)
+	l.syslog.log(syslogInfo, fmt.Sprint(args...), l.fields)
+}
+
+func (l syslogLogger) Error(args ...any) {
+	l.logger.Error(args...)
+	l.syslog.log(syslogError, fmt.Sprint(args...), l.fields)
+}
+
+func (l syslogLogger) Fatal(args ...any) {
+	l.syslog.log(syslogCritical, fmt.Sprint(args...), l.fields)
+	l.logger.Fatal(args...)
+}
+
+func (l syslogLogger) WithField(key string, value any) logger {
+	fields := make(map[string]any, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return syslogLogger{logger: l.logger.WithField(key, value), syslog: l.syslog, fields: fields}
+}
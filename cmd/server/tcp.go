@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// listenTCP opens the listener of the HTTP/1.1 and HTTP/2 fallback.
+// addr is either a "host:port" TCP address or "unix:/path/to.sock".
+func listenTCP(addr string) (net.Listener, error) {
+	path, isUnix := strings.CutPrefix(addr, "unix:")
+	if !isUnix {
+		return net.Listen("tcp", addr)
+	}
+
+	// remove a stale socket left by a previous run, but never a regular file
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a unix socket", path)
+		}
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// newTCPServer creates the server of the fallback listener, advertising the HTTP/3 server with Alt-Svc
+func newTCPServer(quicServer *http3.Server, handler http.Handler, tlsConf *tls.Config) *http.Server {
+	return &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			quicServer.SetQuicHeaders(w.Header())
+			handler.ServeHTTP(w, r)
+		}),
+		TLSConfig: tlsConf.Clone(),
+	}
+}
+
+// serveTCP serves the fallback listener.
+// Unix sockets are expected to sit behind a local reverse proxy terminating TLS, so they serve plain HTTP.
+func serveTCP(server *http.Server, ln net.Listener) error {
+	if ln.Addr().Network() == "unix" {
+		return server.Serve(ln)
+	}
+	return server.ServeTLS(ln, "", "")
+}
+
+// plainHTTPTimeout is the time given to a connection of the TCP fallback to send its first byte, or its
+// request once it is plain HTTP
+const plainHTTPTimeout = 10 * time.Second
+
+// httpsRedirectListener answers the plain HTTP requests sent to the TLS fallback listener with a 308
+// redirect to HTTPS on the same port, and hands the TLS connections over to the server
+type httpsRedirectListener struct {
+	net.Listener
+	conns chan net.Conn
+	errc  chan error
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newHTTPSRedirectListener(ln net.Listener) *httpsRedirectListener {
+	l := &httpsRedirectListener{Listener: ln, conns: make(chan net.Conn), errc: make(chan error, 1), done: make(chan struct{})}
+	go l.accept()
+	return l
+}
+
+func (l *httpsRedirectListener) accept() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			l.errc <- err
+			return
+		}
+		go l.sort(c)
+	}
+}
+
+// sort tells TLS from plain HTTP with the first byte, the one of a TLS handshake record
+func (l *httpsRedirectListener) sort(c net.Conn) {
+	r := bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(plainHTTPTimeout))
+	first, err := r.Peek(1)
+	if err != nil {
+		c.Close()
+		return
+	}
+	if first[0] == 0x16 {
+		c.SetReadDeadline(time.Time{})
+		select {
+		case l.conns <- &peekedConn{Conn: c, r: r}:
+		case <-l.done:
+			c.Close()
+		}
+		return
+	}
+	defer c.Close()
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return
+	}
+	host := req.Host
+	if len(host) == 0 {
+		// HTTP/1.0 requests may have no Host, the address the client connected to is used instead
+		host = c.LocalAddr().String()
+	}
+	target := "https://" + host + req.URL.RequestURI()
+	log.Debugf("Redirecting the plain HTTP request of %s to %s", c.RemoteAddr(), target)
+	rsp := &http.Response{
+		StatusCode: http.StatusPermanentRedirect,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Location": {target}, "Connection": {"close"}},
+		Close:      true,
+	}
+	rsp.Write(c)
+}
+
+func (l *httpsRedirectListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errc:
+		// for the next calls too
+		l.errc <- err
+		return nil, err
+	}
+}
+
+func (l *httpsRedirectListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// peekedConn reads the bytes peeked by httpsRedirectListener before the rest of the connection
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
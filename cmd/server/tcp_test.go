@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newHTTPSRedirectListener(ln)
+	defer l.Close()
+
+	tests := []struct {
+		request, location string
+	}{
+		{"GET /a?b=c HTTP/1.1\r\nHost: example.com\r\n\r\n", "https://example.com/a?b=c"},
+		{"GET /a HTTP/1.1\r\nHost: example.com:8443\r\n\r\n", "https://example.com:8443/a"},
+		{"GET /a HTTP/1.0\r\n\r\n", "https://" + ln.Addr().String() + "/a"},
+	}
+	for _, test := range tests {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte(test.request))
+		rsp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if location := rsp.Header.Get("Location"); rsp.StatusCode != http.StatusPermanentRedirect || location != test.location {
+			t.Errorf("%q: %d to %s, expected %s", test.request, rsp.StatusCode, location, test.location)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultTileCount = 200
+	maxTileCount     = 5000
+	defaultTileSize  = 40
+	maxTileSize      = 1024
+)
+
+// defaultTile is a small 40x40 png
+var defaultTile = []byte{
+	0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d,
+	0x49, 0x48, 0x44, 0x52, 0x00, 0x00, 0x00, 0x28, 0x00, 0x00, 0x00, 0x28,
+	0x01, 0x03, 0x00, 0x00, 0x00, 0xb6, 0x30, 0x2a, 0x2e, 0x00, 0x00, 0x00,
+	0x03, 0x50, 0x4c, 0x54, 0x45, 0x5a, 0xc3, 0x5a, 0xad, 0x38, 0xaa, 0xdb,
+	0x00, 0x00, 0x00, 0x0b, 0x49, 0x44, 0x41, 0x54, 0x78, 0x01, 0x63, 0x18,
+	0x61, 0x00, 0x00, 0x00, 0xf0, 0x00, 0x01, 0xe2, 0xb8, 0x75, 0x22, 0x00,
+	0x00, 0x00, 0x00, 0x49, 0x45, 0x4e, 0x44, 0xae, 0x42, 0x60, 0x82,
+}
+
+// tileParam parses the integer query parameter name, between 1 and max
+func tileParam(r *http.Request, name string, def, max int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if len(v) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 || n > max {
+		return 0, fmt.Errorf("invalid %s, expected 1 to %d", name, max)
+	}
+	return n, nil
+}
+
+// generateTile encodes a size x size png of noise, which does not compress: its length grows with the area
+func generateTile(size int) ([]byte, error) {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	rnd := rand.New(rand.NewSource(int64(size)))
+	for i := 0; i < len(img.Pix); i += 4 {
+		v := rnd.Uint32()
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = byte(v), byte(v>>8), byte(v>>16), 0xff
+	}
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tileHandler serves the 40x40 tile, or a generated one of ?size= pixels
+func tileHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/png")
+	if !r.URL.Query().Has("size") {
+		w.Write(defaultTile)
+		return
+	}
+	size, err := tileParam(r, "size", defaultTileSize, maxTileSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tile, err := generateTile(size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(tile)
+}
+
+// tilesHandler serves a page loading ?count= tiles of ?size= pixels, each one with its own request
+func tilesHandler(w http.ResponseWriter, r *http.Request) {
+	count, err := tileParam(r, "count", defaultTileCount, maxTileCount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	size, err := tileParam(r, "size", defaultTileSize, maxTileSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	src := "/demo/tile?"
+	if r.URL.Query().Has("size") {
+		src += fmt.Sprintf("size=%d&amp;", size)
+	}
+	fmt.Fprintf(w, "<html><head><style>img{width:%dpx;height:%dpx;}</style></head><body>", size, size)
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(w, `<img src="%scachebust=%d">`, src, i)
+	}
+	io.WriteString(w, "</body></html>")
+}
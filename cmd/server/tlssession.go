@@ -0,0 +1,84 @@
+package main
+
+import (
+	"container/list"
+	"crypto/rand"
+	"crypto/tls"
+	"sync"
+)
+
+// sessionIDSize is the size of the random IDs sent as tickets by the session cache
+const sessionIDSize = 16
+
+var tlsSessions = newCounterVec("quicgo_tls_sessions_total", "TLS sessions by event: stored, evicted, resumed and missed in the -tls-session-cache, refused without -tls-resumption", "event")
+
+// tlsSessionCache keeps the resumable TLS sessions on the server, the tickets only carrying their ID:
+// unlike the stateless tickets, the sessions use memory but can be forgotten, the least recently
+// used ones once the cache is full
+type tlsSessionCache struct {
+	mutex    sync.Mutex
+	capacity int
+	lru      *list.List // most recently used IDs first
+	sessions map[string]*list.Element
+}
+
+type cachedSession struct {
+	id    string
+	state []byte
+}
+
+func newTLSSessionCache(capacity int) *tlsSessionCache {
+	return &tlsSessionCache{capacity: capacity, lru: list.New(), sessions: make(map[string]*list.Element)}
+}
+
+// refuseResumption makes conf ignore the session tickets of the clients. The tickets are still sent:
+// quic-go expects one after the handshake and SessionTicketsDisabled leaves the connections hanging.
+func refuseResumption(conf *tls.Config) {
+	conf.UnwrapSession = func([]byte, tls.ConnectionState) (*tls.SessionState, error) {
+		tlsSessions.inc("refused")
+		return nil, nil
+	}
+}
+
+// configure makes conf store its sessions in the cache
+func (c *tlsSessionCache) configure(conf *tls.Config) {
+	conf.WrapSession = c.wrap
+	conf.UnwrapSession = c.unwrap
+}
+
+func (c *tlsSessionCache) wrap(_ tls.ConnectionState, state *tls.SessionState) ([]byte, error) {
+	b, err := state.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, sessionIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sessions[string(id)] = c.lru.PushFront(&cachedSession{id: string(id), state: b})
+	tlsSessions.inc("stored")
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Remove(c.lru.Back()).(*cachedSession)
+		delete(c.sessions, oldest.id)
+		tlsSessions.inc("evicted")
+	}
+	return id, nil
+}
+
+// unwrap returns the session of the ticket, none for a full handshake when it is not in the cache
+func (c *tlsSessionCache) unwrap(identity []byte, _ tls.ConnectionState) (*tls.SessionState, error) {
+	c.mutex.Lock()
+	elem, ok := c.sessions[string(identity)]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mutex.Unlock()
+	if !ok {
+		tlsSessions.inc("missed")
+		return nil, nil
+	}
+	tlsSessions.inc("resumed")
+	return tls.ParseSessionState(elem.Value.(*cachedSession).state)
+}
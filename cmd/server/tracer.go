@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// newMultiplexedTracer combines several connection tracer constructors into one, giving them in their
+// context the logger of the connection, see loggerFrom
+func newMultiplexedTracer(tracers ...func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer) func(context.Context, logging.Perspective, quic.ConnectionID) *logging.ConnectionTracer {
+	if len(tracers) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+		// the tracers log with the logger of the connection
+		ctx = withLogger(ctx, log.WithField("conn_id", connID.String()))
+		var connTracers []*logging.ConnectionTracer
+		for _, t := range tracers {
+			if ct := t(ctx, p, connID); ct != nil {
+				connTracers = append(connTracers, ct)
+			}
+		}
+		return logging.NewMultiplexedConnectionTracer(connTracers...)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Environment of the process started by a binary upgrade
+const (
+	// upgradeFDsEnv lists the names of the inherited sockets, from the file descriptor 3 on
+	upgradeFDsEnv = "QUICGO_UPGRADE_FDS"
+	// upgradeReadyEnv is the descriptor of the pipe on which the new process tells it serves
+	upgradeReadyEnv = "QUICGO_UPGRADE_READY_FD"
+	// upgradeGenerationEnv is the generation of the new process, one more than the previous one
+	upgradeGenerationEnv = "QUICGO_UPGRADE_GENERATION"
+)
+
+// upgradeGeneration is the number of binary upgrades which led to this process, modulo 256. It is the
+// first byte of the connection IDs of the process, for the packets to be routed to the process serving
+// their connection while the previous one drains.
+var upgradeGeneration byte
+
+// connIDLen is the length of the connection IDs issued by the server
+const connIDLen = 8
+
+// generationConnIDs generates random connection IDs starting with the generation of the process
+type generationConnIDs struct{}
+
+func (generationConnIDs) GenerateConnectionID() (quic.ConnectionID, error) {
+	b := make([]byte, connIDLen)
+	if _, err := rand.Read(b[1:]); err != nil {
+		return quic.ConnectionID{}, err
+	}
+	b[0] = upgradeGeneration
+	return quic.ConnectionIDFromBytes(b), nil
+}
+
+func (generationConnIDs) ConnectionIDLen() int {
+	return connIDLen
+}
+
+// upgradeSocket is a socket handed over to the new process, named after its -bind address
+type upgradeSocket struct {
+	name string // "udp <addr>", "route udp <addr>", "tcp <addr>" or "admin"
+	file *os.File
+}
+
+// inheritedFiles are the sockets inherited from the previous process, by name
+var inheritedFiles map[string]*os.File
+
+// loadInheritedSockets reads the sockets passed by the previous process on a binary upgrade
+func loadInheritedSockets() {
+	names := os.Getenv(upgradeFDsEnv)
+	if len(names) == 0 {
+		return
+	}
+	os.Unsetenv(upgradeFDsEnv)
+	generation, _ := strconv.ParseUint(os.Getenv(upgradeGenerationEnv), 10, 8)
+	os.Unsetenv(upgradeGenerationEnv)
+	upgradeGeneration = byte(generation)
+	inheritedFiles = make(map[string]*os.File)
+	for i, name := range strings.Split(names, ",") {
+		fd := listenFDsStart + i
+		inheritedFiles[name] = os.NewFile(uintptr(fd), name)
+	}
+	log.Infof("Inherited %d sockets from the previous process, generation %d", len(inheritedFiles), upgradeGeneration)
+}
+
+// takeInherited returns the inherited socket of the name given, nil when there is none
+func takeInherited(name string) *os.File {
+	f := inheritedFiles[name]
+	delete(inheritedFiles, name)
+	return f
+}
+
+func inheritedPacketConn(name string) (net.PacketConn, error) {
+	f := takeInherited(name)
+	if f == nil {
+		return nil, nil
+	}
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+func inheritedListener(name string) (net.Listener, error) {
+	f := takeInherited(name)
+	if f == nil {
+		return nil, nil
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// signalUpgradeReady tells the previous process it can stop accepting connections
+func signalUpgradeReady() {
+	fd, err := strconv.Atoi(os.Getenv(upgradeReadyEnv))
+	if err != nil {
+		return
+	}
+	os.Unsetenv(upgradeReadyEnv)
+	f := os.NewFile(uintptr(fd), "upgrade-ready")
+	f.Write([]byte{1})
+	f.Close()
+	// the sockets left were not used by this process, e.g. a bind removed from the command line
+	for name, f := range inheritedFiles {
+		log.Warnf("Closing the unused inherited socket %s", name)
+		f.Close()
+	}
+	inheritedFiles = nil
+}
+
+// upgradeSockets returns the sockets of the binds and of the admin listener, for the new process, plus
+// the links on which it forwards the packets of the connections of this process
+func upgradeSockets(bindConfs []bindConfig, adminLn net.Listener) ([]upgradeSocket, error) {
+	type filer interface {
+		File() (*os.File, error)
+	}
+	var socks []upgradeSocket
+	add := func(name string, s any) error {
+		fs, ok := s.(filer)
+		if !ok {
+			return fmt.Errorf("socket %s can't be handed over", name)
+		}
+		f, err := fs.File()
+		if err != nil {
+			return err
+		}
+		socks = append(socks, upgradeSocket{name: name, file: f})
+		return nil
+	}
+	var err error
+	for _, bc := range bindConfs {
+		if err = add("udp "+bc.addr, bc.conn); err != nil {
+			break
+		}
+		var link *os.File
+		if link, err = prepareHandover(bc.conn); err != nil {
+			break
+		}
+		if link != nil {
+			socks = append(socks, upgradeSocket{name: "route udp " + bc.addr, file: link})
+		}
+		if bc.tcpLn != nil {
+			if err = add("tcp "+bc.tcpAddr(), bc.tcpLn); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil && adminLn != nil {
+		err = add("admin", adminLn)
+	}
+	if err != nil {
+		for _, s := range socks {
+			s.file.Close()
+		}
+		return nil, err
+	}
+	return socks, nil
+}
+
+// cancelHandovers closes the links prepared for a new process which did not start
+func cancelHandovers(bindConfs []bindConfig) {
+	for _, bc := range bindConfs {
+		cancelHandover(bc.conn)
+	}
+}
+
+// handOvers lets the new process read the UDP sockets, this one reading the packets it forwards
+func handOvers(bindConfs []bindConfig) {
+	for _, bc := range bindConfs {
+		handOver(bc.conn)
+	}
+}
+
+// trackingListener counts the connections accepted which are still open
+type trackingListener struct {
+	http3.QUICEarlyListener
+	active *atomic.Int64
+}
+
+func (l *trackingListener) Accept(ctx context.Context) (quic.EarlyConnection, error) {
+	conn, err := l.QUICEarlyListener.Accept(ctx)
+	if err == nil {
+		l.active.Add(1)
+		go func() {
+			<-conn.Context().Done()
+			l.active.Add(-1)
+		}()
+	}
+	return conn, err
+}
+
+// waitDrained waits until all the connections counted in active are closed, or until ctx is done
+func waitDrained(ctx context.Context, active *atomic.Int64) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for active.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Warnf("Closing the %d connections still open", active.Load())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// keepUnixSockets keeps the unix socket files of the TCP fallbacks, used by the new process, when the listeners are closed
+func keepUnixSockets(bindConfs []bindConfig) {
+	for _, bc := range bindConfs {
+		if ln, ok := bc.tcpLn.(*net.UnixListener); ok {
+			ln.SetUnlinkOnClose(false)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+)
+
+func notifyUpgrade(ch chan<- os.Signal) {}
+
+func startUpgrade(socks []upgradeSocket) error {
+	for _, s := range socks {
+		s.file.Close()
+	}
+	return errors.New("binary upgrades are not supported on this platform")
+}
+
+func routePackets(conn net.PacketConn, link *os.File) net.PacketConn {
+	if link != nil {
+		link.Close()
+	}
+	return conn
+}
+
+func prepareHandover(conn net.PacketConn) (*os.File, error) {
+	return nil, nil
+}
+
+func cancelHandover(conn net.PacketConn) {}
+
+func handOver(conn net.PacketConn) {}
+
+func waitRelayed(ctx context.Context, conn net.PacketConn) {}
@@ -0,0 +1,76 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// upgradeTimeout is how long the new process may take to start serving
+const upgradeTimeout = 30 * time.Second
+
+// notifyUpgrade relays the SIGUSR2 signals requesting a binary upgrade to ch
+func notifyUpgrade(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR2)
+}
+
+// startUpgrade starts the binary of the process again, with the same arguments, handing over socks.
+// It returns once the new process is serving, or with an error when it exited or timed out.
+func startUpgrade(socks []upgradeSocket) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	var names []string
+	var files []*os.File
+	for _, s := range socks {
+		names = append(names, s.name)
+		files = append(files, s.file)
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(os.Environ(),
+		upgradeFDsEnv+"="+strings.Join(names, ","),
+		fmt.Sprintf("%s=%d", upgradeReadyEnv, listenFDsStart+len(files)),
+		fmt.Sprintf("%s=%d", upgradeGenerationEnv, upgradeGeneration+1))
+	err = cmd.Start()
+	readyW.Close()
+	for _, f := range files {
+		f.Close()
+	}
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+
+	done := make(chan error, 1)
+	go func() {
+		// the pipe is closed without a byte written when the new process exits
+		_, err := ready.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return errors.New("new process exited before serving")
+		}
+		return nil
+	case <-time.After(upgradeTimeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("new process not serving after %v", upgradeTimeout)
+	}
+}